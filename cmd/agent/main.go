@@ -10,13 +10,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/scylladb/sct-agent/internal/api"
+	"github.com/scylladb/sct-agent/internal/audit"
+	"github.com/scylladb/sct-agent/internal/auth"
 	"github.com/scylladb/sct-agent/internal/executor"
+	"github.com/scylladb/sct-agent/internal/health"
+	"github.com/scylladb/sct-agent/internal/metrics"
 	"github.com/scylladb/sct-agent/internal/storage"
 )
 
@@ -24,65 +29,145 @@ type Config struct {
 	Server struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
+
+		TLS struct {
+			CertFile              string `yaml:"cert_file"`
+			KeyFile               string `yaml:"key_file"`
+			ClientCAFile          string `yaml:"client_ca_file"`
+			RequireClientCert     bool   `yaml:"require_client_cert"`
+			ReloadIntervalSeconds int    `yaml:"reload_interval_seconds"`
+		} `yaml:"tls"`
 	} `yaml:"server"`
 
 	Security struct {
-		APIKeys []string `yaml:"api_keys"`
+		APIKeys []auth.Key `yaml:"api_keys"`
 	} `yaml:"security"`
 
+	RateLimits struct {
+		Global api.RateLimit `yaml:"global"`
+		PerKey api.RateLimit `yaml:"per_key"`
+	} `yaml:"rate_limits"`
+
 	Executor struct {
-		MaxConcurrentJobs     int `yaml:"max_concurrent_jobs"`
-		DefaultTimeoutSeconds int `yaml:"default_timeout_seconds"`
+		MaxConcurrentJobs     int    `yaml:"max_concurrent_jobs"`
+		DefaultTimeoutSeconds int    `yaml:"default_timeout_seconds"`
+		MaxQueuedJobs         int    `yaml:"max_queued_jobs"`
+		IdempotencyWindowSecs int    `yaml:"idempotency_window_seconds"`
+		OutputDir             string `yaml:"output_dir"`
+		OutputLimitBytes      int64  `yaml:"output_limit_bytes"`
+		MaxStdinBytes         int64  `yaml:"max_stdin_bytes"`
+		StarvationTimeoutSecs int    `yaml:"starvation_timeout_seconds"`
+		KillSignal            string `yaml:"kill_signal"`
+		GracePeriodSeconds    int    `yaml:"grace_period_seconds"`
+		CgroupParent          string `yaml:"cgroup_parent"`
+
+		Env struct {
+			DefaultMode string            `yaml:"default_mode"`
+			Base        map[string]string `yaml:"base"`
+			Deny        []string          `yaml:"deny"`
+			Sensitive   []string          `yaml:"sensitive"`
+		} `yaml:"env"`
 	} `yaml:"executor"`
 
+	Sessions struct {
+		MaxSessions        int `yaml:"max_sessions"`
+		IdleTimeoutSeconds int `yaml:"idle_timeout_seconds"`
+	} `yaml:"sessions"`
+
+	Files struct {
+		WritableRoots []string `yaml:"writable_roots"`
+		ReadableRoots []string `yaml:"readable_roots"`
+	} `yaml:"files"`
+
 	Logging struct {
 		Level string `yaml:"level"`
 	} `yaml:"logging"`
 
+	Audit struct {
+		File       string `yaml:"file"`
+		MaxSizeMB  int    `yaml:"max_size_mb"`
+		MaxBackups int    `yaml:"max_backups"`
+	} `yaml:"audit"`
+
 	Storage struct {
 		Type                 string `yaml:"type"`
+		DataDir              string `yaml:"data_dir"`
 		CleanupIntervalHours int    `yaml:"cleanup_interval_hours"`
+		Retention            struct {
+			MaxAgeHours       int `yaml:"max_age_hours"`
+			FailedMaxAgeHours int `yaml:"failed_max_age_hours"`
+			MaxJobs           int `yaml:"max_jobs"`
+			MaxOutputMB       int `yaml:"max_output_mb"`
+		} `yaml:"retention"`
 	} `yaml:"storage"`
+
+	Callbacks struct {
+		Default               *storage.Callback `yaml:"default"`
+		MaxAttempts           int               `yaml:"max_attempts"`
+		InitialBackoffSeconds int               `yaml:"initial_backoff_seconds"`
+		TimeoutSeconds        int               `yaml:"timeout_seconds"`
+	} `yaml:"callbacks"`
+
+	Health struct {
+		CheckIntervalSeconds int    `yaml:"check_interval_seconds"`
+		CheckTimeoutSeconds  int    `yaml:"check_timeout_seconds"`
+		MemoryDegradedMB     uint64 `yaml:"memory_degraded_mb"`
+		MemoryUnhealthyMB    uint64 `yaml:"memory_unhealthy_mb"`
+		DiskFreeDegradedMB   uint64 `yaml:"disk_free_degraded_mb"`
+		DiskFreeUnhealthyMB  uint64 `yaml:"disk_free_unhealthy_mb"`
+		StorageLatencyMs     int    `yaml:"storage_latency_ms"`
+		StuckJobSlackSeconds int    `yaml:"stuck_job_slack_seconds"`
+	} `yaml:"health"`
 }
 
 func getDefaultConfig() *Config {
-	return &Config{
-		Server: struct {
-			Host string `yaml:"host"`
-			Port int    `yaml:"port"`
-		}{
-			Host: "0.0.0.0",
-			Port: 16000,
-		},
-
-		Security: struct {
-			APIKeys []string `yaml:"api_keys"`
-		}{
-			APIKeys: []string{"default-api-key"},
-		},
-
-		Executor: struct {
-			MaxConcurrentJobs     int `yaml:"max_concurrent_jobs"`
-			DefaultTimeoutSeconds int `yaml:"default_timeout_seconds"`
-		}{
-			MaxConcurrentJobs:     10,
-			DefaultTimeoutSeconds: 1800,
-		},
-
-		Logging: struct {
-			Level string `yaml:"level"`
-		}{
-			Level: "info",
-		},
-
-		Storage: struct {
-			Type                 string `yaml:"type"`
-			CleanupIntervalHours int    `yaml:"cleanup_interval_hours"`
-		}{
-			Type:                 "memory",
-			CleanupIntervalHours: 24,
-		},
-	}
+	config := &Config{}
+
+	config.Server.Host = "0.0.0.0"
+	config.Server.Port = 16000
+	config.Server.TLS.ReloadIntervalSeconds = 60
+
+	config.Security.APIKeys = []auth.Key{{Key: "default-api-key"}}
+
+	config.Executor.MaxConcurrentJobs = 10
+	config.Executor.DefaultTimeoutSeconds = 1800
+	config.Executor.IdempotencyWindowSecs = 86400
+	config.Executor.OutputDir = "/var/lib/sct-agent/output"
+	config.Executor.OutputLimitBytes = 1 << 20 // 1 MB
+	config.Executor.MaxStdinBytes = 64 << 20   // 64 MB
+	config.Executor.StarvationTimeoutSecs = 300
+	config.Executor.KillSignal = "SIGTERM"
+	config.Executor.GracePeriodSeconds = 10
+	config.Executor.Env.DefaultMode = executor.EnvModeMerge
+	config.Executor.Env.Deny = []string{"SCT_AGENT_API_KEY"}
+	config.Executor.Env.Sensitive = []string{"*PASSWORD*", "*SECRET*", "*TOKEN*", "*_KEY", "*CREDENTIALS*"}
+	config.Sessions.MaxSessions = 10
+	config.Sessions.IdleTimeoutSeconds = 900
+
+	config.Logging.Level = "info"
+
+	config.Audit.MaxSizeMB = 100
+	config.Audit.MaxBackups = 5
+
+	config.Storage.Type = "memory"
+	config.Storage.DataDir = "/var/lib/sct-agent"
+	config.Storage.CleanupIntervalHours = 24
+	config.Storage.Retention.MaxAgeHours = 168
+
+	config.Callbacks.MaxAttempts = 5
+	config.Callbacks.InitialBackoffSeconds = 1
+	config.Callbacks.TimeoutSeconds = 10
+
+	config.Health.CheckIntervalSeconds = 10
+	config.Health.CheckTimeoutSeconds = 5
+	config.Health.MemoryDegradedMB = 1024
+	config.Health.MemoryUnhealthyMB = 4096
+	config.Health.DiskFreeDegradedMB = 1024
+	config.Health.DiskFreeUnhealthyMB = 100
+	config.Health.StorageLatencyMs = 1000
+	config.Health.StuckJobSlackSeconds = 60
+
+	return config
 }
 
 func loadConfig(configPath string) (*Config, error) {
@@ -108,7 +193,7 @@ func loadConfig(configPath string) (*Config, error) {
 	// load API key from environment variable if set
 	if apiKey := os.Getenv("SCT_AGENT_API_KEY"); apiKey != "" {
 		log.Println("Loading API key from SCT_AGENT_API_KEY environment variable")
-		config.Security.APIKeys = append(config.Security.APIKeys, apiKey)
+		config.Security.APIKeys = append(config.Security.APIKeys, auth.Key{Name: "env", Key: apiKey})
 	}
 
 	if err = validateConfig(config); err != nil {
@@ -123,10 +208,34 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("invalid port number: %d", config.Server.Port)
 	}
 
+	tlsConfig := config.Server.TLS
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		return fmt.Errorf("both server.tls.cert_file and server.tls.key_file must be set to enable TLS")
+	}
+	if tlsConfig.ClientCAFile != "" && tlsConfig.CertFile == "" {
+		return fmt.Errorf("server.tls.client_ca_file requires server.tls.cert_file and server.tls.key_file")
+	}
+	if tlsConfig.RequireClientCert && tlsConfig.ClientCAFile == "" {
+		return fmt.Errorf("server.tls.require_client_cert requires server.tls.client_ca_file")
+	}
+	if tlsConfig.ReloadIntervalSeconds <= 0 {
+		return fmt.Errorf("server.tls.reload_interval_seconds must be greater than 0")
+	}
+
 	if len(config.Security.APIKeys) == 0 {
 		return fmt.Errorf("at least one API key must be configured")
 	}
 
+	if _, err := auth.NewKeyring(config.Security.APIKeys); err != nil {
+		return err
+	}
+
+	for name, limit := range map[string]api.RateLimit{"global": config.RateLimits.Global, "per_key": config.RateLimits.PerKey} {
+		if limit.RequestsPerSecond < 0 || limit.Burst < 0 {
+			return fmt.Errorf("rate_limits.%s must not be negative", name)
+		}
+	}
+
 	if config.Executor.MaxConcurrentJobs <= 0 {
 		return fmt.Errorf("max_concurrent_jobs must be greater than 0")
 	}
@@ -135,9 +244,108 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("default_timeout_seconds must be greater than 0")
 	}
 
+	if config.Executor.MaxQueuedJobs < 0 {
+		return fmt.Errorf("max_queued_jobs must not be negative")
+	}
+
+	if config.Executor.IdempotencyWindowSecs < 0 {
+		return fmt.Errorf("idempotency_window_seconds must not be negative")
+	}
+
+	if config.Executor.OutputLimitBytes < 0 {
+		return fmt.Errorf("output_limit_bytes must not be negative")
+	}
+
+	if config.Executor.MaxStdinBytes < 0 {
+		return fmt.Errorf("max_stdin_bytes must not be negative")
+	}
+
+	for _, root := range config.Files.WritableRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("writable root must be an absolute path: %s", root)
+		}
+	}
+	for _, root := range config.Files.ReadableRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("readable root must be an absolute path: %s", root)
+		}
+	}
+
+	if _, err := executor.ParseSignal(config.Executor.KillSignal); err != nil {
+		return fmt.Errorf("invalid kill_signal: %w", err)
+	}
+
+	if config.Executor.GracePeriodSeconds < 0 {
+		return fmt.Errorf("grace_period_seconds must not be negative")
+	}
+
+	if err := executor.ValidateEnvConfig(envConfig(config)); err != nil {
+		return fmt.Errorf("invalid executor.env: %w", err)
+	}
+
+	if config.Executor.CgroupParent != "" && !filepath.IsAbs(config.Executor.CgroupParent) {
+		return fmt.Errorf("cgroup_parent must be an absolute path: %s", config.Executor.CgroupParent)
+	}
+
+	if config.Storage.CleanupIntervalHours <= 0 {
+		return fmt.Errorf("cleanup_interval_hours must be greater than 0")
+	}
+
+	retention := config.Storage.Retention
+	if retention.MaxAgeHours < 0 || retention.FailedMaxAgeHours < 0 || retention.MaxJobs < 0 || retention.MaxOutputMB < 0 {
+		return fmt.Errorf("storage.retention limits must not be negative")
+	}
+
+	if config.Audit.File != "" && config.Audit.MaxSizeMB <= 0 {
+		return fmt.Errorf("audit.max_size_mb must be greater than 0")
+	}
+
+	if config.Audit.MaxBackups < 0 {
+		return fmt.Errorf("audit.max_backups must not be negative")
+	}
+
+	if config.Sessions.MaxSessions < 0 {
+		return fmt.Errorf("sessions.max_sessions must not be negative")
+	}
+
+	if config.Sessions.IdleTimeoutSeconds <= 0 {
+		return fmt.Errorf("sessions.idle_timeout_seconds must be greater than 0")
+	}
+
+	if config.Callbacks.Default != nil {
+		if err := executor.ValidateCallback(config.Callbacks.Default); err != nil {
+			return fmt.Errorf("invalid callbacks.default: %w", err)
+		}
+	}
+
+	if config.Callbacks.MaxAttempts <= 0 {
+		return fmt.Errorf("callbacks.max_attempts must be greater than 0")
+	}
+
+	if config.Callbacks.InitialBackoffSeconds <= 0 || config.Callbacks.TimeoutSeconds <= 0 {
+		return fmt.Errorf("callbacks.initial_backoff_seconds and callbacks.timeout_seconds must be greater than 0")
+	}
+
+	if config.Health.CheckIntervalSeconds <= 0 {
+		return fmt.Errorf("health.check_interval_seconds must be greater than 0")
+	}
+
+	if config.Health.CheckTimeoutSeconds <= 0 {
+		return fmt.Errorf("health.check_timeout_seconds must be greater than 0")
+	}
+
 	return nil
 }
 
+func envConfig(config *Config) executor.EnvConfig {
+	return executor.EnvConfig{
+		DefaultMode: config.Executor.Env.DefaultMode,
+		Base:        config.Executor.Env.Base,
+		Deny:        config.Executor.Env.Deny,
+		Sensitive:   config.Executor.Env.Sensitive,
+	}
+}
+
 func configureSlog(level string, logFilePath string) {
 	logLevels := map[string]slog.Level{
 		"debug": slog.LevelDebug,
@@ -174,6 +382,29 @@ func configureSlog(level string, logFilePath string) {
 	slog.SetDefault(logger)
 }
 
+func newHealthChecker(config *Config, exec *executor.Executor, store storage.Storage, logFilePath string) *health.Checker {
+	const mb = 1024 * 1024
+
+	diskPaths := []string{config.Executor.OutputDir}
+	if config.Storage.Type == "file" {
+		diskPaths = append(diskPaths, config.Storage.DataDir)
+	}
+	if logFilePath != "" {
+		diskPaths = append(diskPaths, filepath.Dir(logFilePath))
+	}
+	if config.Audit.File != "" {
+		diskPaths = append(diskPaths, filepath.Dir(config.Audit.File))
+	}
+
+	return health.NewChecker(time.Duration(config.Health.CheckTimeoutSeconds)*time.Second,
+		health.ExecutorSaturation(exec),
+		health.MemoryUsage(config.Health.MemoryDegradedMB*mb, config.Health.MemoryUnhealthyMB*mb),
+		health.DiskFree(diskPaths, config.Health.DiskFreeDegradedMB*mb, config.Health.DiskFreeUnhealthyMB*mb),
+		health.StorageResponsiveness(store, time.Duration(config.Health.StorageLatencyMs)*time.Millisecond),
+		health.StuckJobs(store, time.Duration(config.Health.StuckJobSlackSeconds)*time.Second),
+	)
+}
+
 const version = "0.0.1"
 
 func main() {
@@ -193,6 +424,7 @@ func main() {
 	slog.Info("Starting SCT Agent", "version", version)
 	slog.Info("Server configuration", "host", config.Server.Host, "port", config.Server.Port)
 	slog.Info("Executor configuration", "max_concurrent_jobs", config.Executor.MaxConcurrentJobs, "default_timeout_seconds", config.Executor.DefaultTimeoutSeconds)
+	slog.Info("Files configuration", "writable_roots", config.Files.WritableRoots, "readable_roots", config.Files.ReadableRoots)
 	slog.Info("Logging configuration", "level", config.Logging.Level)
 
 	var store storage.Storage
@@ -200,25 +432,135 @@ func main() {
 	case "memory":
 		store = storage.NewMemory()
 		slog.Info("Storage initialized", "type", "memory")
+	case "file":
+		fileStore, err := storage.NewFile(config.Storage.DataDir)
+		if err != nil {
+			slog.Error("Failed to initialize storage", "type", "file", "data_dir", config.Storage.DataDir, "error", err)
+			os.Exit(1)
+		}
+		store = fileStore
+		slog.Info("Storage initialized", "type", "file", "data_dir", config.Storage.DataDir)
 	default:
 		slog.Error("Unsupported storage type", "type", config.Storage.Type)
 		os.Exit(1)
 	}
 
-	exec := executor.NewExecutor(config.Executor.MaxConcurrentJobs, store)
+	var cgroups *executor.Cgroups
+	if config.Executor.CgroupParent != "" {
+		cgroups, err = executor.NewCgroups(config.Executor.CgroupParent)
+		if err != nil {
+			slog.Error("Failed to initialize cgroups", "cgroup_parent", config.Executor.CgroupParent, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Cgroups initialized", "cgroup_parent", config.Executor.CgroupParent)
+	}
+
+	var auditLog *audit.Log
+	if config.Audit.File != "" {
+		auditLog, err = audit.Open(config.Audit.File, int64(config.Audit.MaxSizeMB)*1024*1024, config.Audit.MaxBackups)
+		if err != nil {
+			slog.Error("Failed to open audit log", "file", config.Audit.File, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Audit log enabled", "file", config.Audit.File)
+	}
+
+	agentMetrics := metrics.New()
+
+	exec := executor.NewExecutor(executor.Config{
+		MaxConcurrentJobs:     config.Executor.MaxConcurrentJobs,
+		DefaultTimeoutSeconds: config.Executor.DefaultTimeoutSeconds,
+		MaxQueuedJobs:         config.Executor.MaxQueuedJobs,
+		IdempotencyWindow:     time.Duration(config.Executor.IdempotencyWindowSecs) * time.Second,
+		OutputDir:             config.Executor.OutputDir,
+		OutputLimitBytes:      config.Executor.OutputLimitBytes,
+		StarvationTimeout:     time.Duration(config.Executor.StarvationTimeoutSecs) * time.Second,
+		KillSignal:            config.Executor.KillSignal,
+		GracePeriod:           time.Duration(config.Executor.GracePeriodSeconds) * time.Second,
+		Metrics:               agentMetrics,
+		Cgroups:               cgroups,
+		Env:                   envConfig(config),
+		MaxSessions:           config.Sessions.MaxSessions,
+		SessionIdleTimeout:    time.Duration(config.Sessions.IdleTimeoutSeconds) * time.Second,
+		Retention: storage.RetentionPolicy{
+			MaxAge:         time.Duration(config.Storage.Retention.MaxAgeHours) * time.Hour,
+			FailedMaxAge:   time.Duration(config.Storage.Retention.FailedMaxAgeHours) * time.Hour,
+			MaxJobs:        config.Storage.Retention.MaxJobs,
+			MaxOutputBytes: int64(config.Storage.Retention.MaxOutputMB) * 1024 * 1024,
+		},
+		Callbacks: executor.CallbackConfig{
+			Default:        config.Callbacks.Default,
+			MaxAttempts:    config.Callbacks.MaxAttempts,
+			InitialBackoff: time.Duration(config.Callbacks.InitialBackoffSeconds) * time.Second,
+			Timeout:        time.Duration(config.Callbacks.TimeoutSeconds) * time.Second,
+		},
+	}, store)
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
+	healthChecker := newHealthChecker(config, exec, store, logFilePath)
+	healthChecker.Run(watchCtx)
+	go healthChecker.Watch(watchCtx, time.Duration(config.Health.CheckIntervalSeconds)*time.Second)
+	go exec.WatchRetention(watchCtx, time.Duration(config.Storage.CleanupIntervalHours)*time.Hour)
+
+	keyring, err := auth.NewKeyring(config.Security.APIKeys)
+	if err != nil {
+		slog.Error("Failed to load API keys", "error", err)
+		os.Exit(1)
+	}
 
 	httpServer := &http.Server{
-		Addr:           fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
-		Handler:        api.New(exec, config.Security.APIKeys, version).SetupRoutes(),
+		Addr: fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
+		Handler: api.New(exec, api.Config{
+			Keyring:         keyring,
+			Version:         version,
+			WritableRoots:   config.Files.WritableRoots,
+			ReadableRoots:   config.Files.ReadableRoots,
+			MaxStdinBytes:   config.Executor.MaxStdinBytes,
+			Metrics:         agentMetrics,
+			Health:          healthChecker,
+			Audit:           auditLog,
+			GlobalRateLimit: config.RateLimits.Global,
+			KeyRateLimit:    config.RateLimits.PerKey,
+		}).SetupRoutes(),
 		ReadTimeout:    30 * time.Second,
 		WriteTimeout:   30 * time.Second,
 		IdleTimeout:    60 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 1 MB
 	}
 
+	useTLS := config.Server.TLS.CertFile != ""
+	if useTLS {
+		reloader, err := api.NewCertificateReloader(api.TLSConfig{
+			CertFile:          config.Server.TLS.CertFile,
+			KeyFile:           config.Server.TLS.KeyFile,
+			ClientCAFile:      config.Server.TLS.ClientCAFile,
+			RequireClientCert: config.Server.TLS.RequireClientCert,
+			ReloadInterval:    time.Duration(config.Server.TLS.ReloadIntervalSeconds) * time.Second,
+		})
+		if err != nil {
+			slog.Error("Failed to load TLS certificates", "error", err)
+			os.Exit(1)
+		}
+		httpServer.TLSConfig = reloader.TLSConfig()
+		go reloader.Watch(watchCtx)
+		slog.Info("TLS enabled",
+			"cert_file", config.Server.TLS.CertFile,
+			"client_ca_file", config.Server.TLS.ClientCAFile,
+			"require_client_cert", config.Server.TLS.RequireClientCert)
+	}
+
 	go func() {
-		slog.Info("SCT Agent listening", "addr", httpServer.Addr)
-		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Info("SCT Agent listening", "addr", httpServer.Addr, "tls", useTLS)
+		var err error
+		if useTLS {
+			// the certificates are provided by httpServer.TLSConfig
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error("Failed to start server", "error", err)
 			os.Exit(1)
 		}
@@ -240,6 +582,7 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		slog.Error("HTTP server shutdown error", "error", err)
 	}
+	auditLog.Close()
 
 	slog.Info("SCT Agent stopped")
 }
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scylladb/sct-agent/internal/auth"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const checksumHeader = "X-Checksum-SHA256"
+
+var (
+	errOutsideWritableRoots = errors.New("path is outside of the writable roots")
+	errChecksumMismatch     = errors.New("checksum mismatch")
+)
+
+// handles PUT /api/v1/files?path=
+//
+// The body is written into a temporary file next to the target, which is renamed over the target once
+// the upload is complete, so readers never see a partially written file. Optional query parameters:
+// mode (octal permission bits, by default those of the replaced file or 0644), owner and group (names or
+// numeric IDs, which the key policy has to allow), create_dirs. When the X-Checksum-SHA256 request header is set, the upload is rejected if
+// the content does not match it.
+func (s *Server) uploadFile(c *gin.Context) {
+	if err := identityFrom(c).Policy.AuthorizeWrite(); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Upload not allowed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	path, err := s.writablePath(identityFrom(c).Policy, c.Query("path"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errOutsideWritableRoots) || errors.Is(err, auth.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Invalid file path",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	mode := os.FileMode(0o644)
+	if modeParam := c.Query("mode"); modeParam != "" {
+		value, err := strconv.ParseUint(modeParam, 8, 32)
+		if err != nil || value > 0o777 {
+			c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+				Error:   "Invalid file mode",
+				Message: fmt.Sprintf("mode must be octal permission bits, got %q", modeParam),
+			})
+			return
+		}
+		mode = os.FileMode(value)
+	} else if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		// a replaced file keeps its permissions
+		mode = info.Mode().Perm()
+	}
+
+	if err := identityFrom(c).Policy.AuthorizeOwner(c.Query("owner"), c.Query("group")); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Invalid file owner",
+			Message: err.Error(),
+		})
+		return
+	}
+	uid, gid, err := lookupOwner(c.Query("owner"), c.Query("group"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Invalid file owner",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if c.Query("create_dirs") == "true" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			c.JSON(http.StatusInternalServerError, storage.ErrorResponse{
+				Error:   "Upload failed",
+				Message: fmt.Sprintf("failed to create parent directories: %v", err),
+			})
+			return
+		}
+	}
+
+	// large uploads may outlive the server read timeout
+	_ = http.NewResponseController(c.Writer).SetReadDeadline(time.Time{})
+
+	info, err := writeFileAtomically(path, c.Request.Body, mode, uid, gid, c.GetHeader(checksumHeader))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errChecksumMismatch) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Upload failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.Header(checksumHeader, info.SHA256)
+	c.JSON(http.StatusOK, info)
+}
+
+// handles GET /api/v1/files?path=
+//
+// Files outside of the readable roots and the allowed working directories of the key require an unrestricted
+// key, see auth.Policy.AuthorizeRead. Range requests are supported. X-Checksum-SHA256 holds the checksum of the whole file.
+func (s *Server) downloadFile(c *gin.Context) {
+	path, err := s.readablePath(identityFrom(c).Policy, c.Query("path"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrForbidden) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Download not allowed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		} else if os.IsPermission(err) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Cannot open file",
+			Message: err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Cannot download file",
+			Message: fmt.Sprintf("%s is not a regular file", path),
+		})
+		return
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		c.JSON(http.StatusInternalServerError, storage.ErrorResponse{
+			Error:   "Cannot read file",
+			Message: err.Error(),
+		})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, storage.ErrorResponse{
+			Error:   "Cannot read file",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// large downloads may outlive the server write timeout
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header(checksumHeader, hex.EncodeToString(hash.Sum(nil)))
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), stat.ModTime(), file)
+}
+
+// writablePath validates that path is absolute, located within one of the writable roots and that the policy
+// allows writing it. Symlinks in the parent directory are resolved, so they cannot be used to escape the roots.
+func (s *Server) writablePath(policy *auth.Policy, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	path = filepath.Clean(path)
+
+	dir := filepath.Dir(path)
+	resolved := dir
+	// the parent directory may not exist yet when it is to be created
+	for {
+		if r, err := filepath.EvalSymlinks(resolved); err == nil {
+			resolved = filepath.Join(r, strings.TrimPrefix(dir, resolved))
+			break
+		}
+		parent := filepath.Dir(resolved)
+		if parent == resolved {
+			break
+		}
+		resolved = parent
+	}
+	target := filepath.Join(resolved, filepath.Base(path))
+
+	for _, root := range s.config.WritableRoots {
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+		root = filepath.Clean(root)
+		if target != root && strings.HasPrefix(target, strings.TrimSuffix(root, "/")+"/") {
+			if err := policy.AuthorizeUpload(target); err != nil {
+				return "", err
+			}
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errOutsideWritableRoots, path)
+}
+
+// readablePath validates that path is absolute and that the policy allows reading it, resolving its symlinks so
+// that they cannot be used to escape the readable roots. A missing file is checked by its path, which does not
+// disclose whether it exists to keys which cannot read it.
+func (s *Server) readablePath(policy *auth.Policy, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	path = filepath.Clean(path)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if err := policy.AuthorizeRead(path, s.config.ReadableRoots); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		if id, err := strconv.Atoi(owner); err == nil {
+			uid = id
+		} else {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown owner %q: %w", owner, err)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+
+	if group != "" {
+		if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown group %q: %w", group, err)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+func writeFileAtomically(path string, body io.Reader, mode os.FileMode, uid, gid int, expectedChecksum string) (*storage.FileInfo, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive file: %w", err)
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expectedChecksum != "" && !strings.EqualFold(expectedChecksum, checksum) {
+		return nil, fmt.Errorf("%w: expected %s, got %s", errChecksumMismatch, expectedChecksum, checksum)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return nil, fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if uid != -1 || gid != -1 {
+		if err := tmp.Chown(uid, gid); err != nil {
+			return nil, fmt.Errorf("failed to set file owner: %w", err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	return &storage.FileInfo{
+		Path:   path,
+		Size:   size,
+		Mode:   fmt.Sprintf("%04o", mode.Perm()),
+		SHA256: checksum,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/auth"
+)
+
+func TestWritablePath(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "inside")))
+	s := &Server{config: Config{WritableRoots: []string{root}}}
+
+	for name, path := range map[string]string{
+		"file":              filepath.Join(root, "file"),
+		"missing directory": filepath.Join(root, "missing", "nested", "file"),
+		"symlink inside":    filepath.Join(root, "inside", "file"),
+	} {
+		got, err := s.writablePath(&auth.Policy{}, path)
+		require.NoError(t, err, name)
+		assert.Equal(t, path, got, name)
+	}
+
+	for name, path := range map[string]string{
+		"symlinked parent":         filepath.Join(root, "escape", "file"),
+		"symlinked missing parent": filepath.Join(root, "escape", "missing", "file"),
+		"outside":                  filepath.Join(outside, "file"),
+		"traversal":                filepath.Join(root, "..", "file"),
+		"root":                     root,
+	} {
+		_, err := s.writablePath(&auth.Policy{}, path)
+		assert.ErrorIs(t, err, errOutsideWritableRoots, name)
+	}
+
+	_, err := s.writablePath(&auth.Policy{}, "relative/file")
+	assert.Error(t, err)
+}
+
+func TestUploadFileRestricted(t *testing.T) {
+	root := t.TempDir()
+	allowed := filepath.Join(root, "allowed")
+	require.NoError(t, os.Mkdir(allowed, 0o755))
+	require.NoError(t, os.Symlink(root, filepath.Join(allowed, "escape")))
+	server, _ := newTestServer(t, Config{WritableRoots: []string{root}},
+		auth.Key{Name: "tmp", Key: "tmp-key", Policy: auth.Policy{AllowedWorkingDirs: []string{allowed}}},
+		auth.Key{Name: "echo", Key: "echo-key", Policy: auth.Policy{AllowedCommands: []string{"echo"}}})
+
+	upload := func(key, path string) int {
+		resp := doRequest(t, server, http.MethodPut, "/api/v1/files?path="+url.QueryEscape(path), key,
+			"application/octet-stream", strings.NewReader("content"))
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, upload("tmp-key", filepath.Join(allowed, "file")))
+	assert.Equal(t, http.StatusForbidden, upload("tmp-key", filepath.Join(root, "script.sh")),
+		"restricted keys write only under their allowed working directories")
+	assert.Equal(t, http.StatusForbidden, upload("tmp-key", filepath.Join(allowed, "escape", "script.sh")))
+	assert.Equal(t, http.StatusForbidden, upload("echo-key", filepath.Join(root, "script.sh")))
+	assert.NoFileExists(t, filepath.Join(root, "script.sh"))
+	assert.Equal(t, http.StatusOK, upload(adminKey, filepath.Join(root, "script.sh")))
+}
+
+func TestUploadFileOwner(t *testing.T) {
+	root := t.TempDir()
+	server, _ := newTestServer(t, Config{WritableRoots: []string{root}})
+
+	for name, query := range map[string]string{
+		"owner": "&owner=root",
+		"uid":   "&owner=0",
+		"group": "&group=0",
+	} {
+		path := filepath.Join(root, name)
+		resp := doRequest(t, server, http.MethodPut, "/api/v1/files?path="+url.QueryEscape(path)+query, adminKey,
+			"application/octet-stream", strings.NewReader("content"))
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "%s not allowed by the key", name)
+		assert.NoFileExists(t, path, name)
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(path, []byte("old content"), 0o600))
+
+	info, err := writeFileAtomically(path, strings.NewReader("new"), 0o640, -1, -1, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), info.Size)
+	assert.Equal(t, "0640", info.Mode)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data), "the existing file is replaced")
+
+	_, err = writeFileAtomically(path, strings.NewReader("other"), 0o640, -1, -1, info.SHA256)
+	assert.ErrorIs(t, err, errChecksumMismatch)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data), "a rejected upload leaves the file alone")
+
+	_, err = writeFileAtomically(filepath.Join(dir, "missing", "file"), strings.NewReader("new"), 0o644, -1, -1, "")
+	assert.Error(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+}
+
+func TestUploadFileMode(t *testing.T) {
+	root := t.TempDir()
+	server, _ := newTestServer(t, Config{WritableRoots: []string{root}})
+
+	upload := func(path, query string) *http.Response {
+		return doRequest(t, server, http.MethodPut, "/api/v1/files?path="+url.QueryEscape(path)+query, adminKey,
+			"application/octet-stream", strings.NewReader("content"))
+	}
+	mode := func(path string) os.FileMode {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		return info.Mode().Perm()
+	}
+
+	existing := filepath.Join(root, "existing")
+	require.NoError(t, os.WriteFile(existing, nil, 0o600))
+	require.Equal(t, http.StatusOK, upload(existing, "").StatusCode)
+	assert.Equal(t, os.FileMode(0o600), mode(existing), "a replaced file keeps its mode")
+
+	require.Equal(t, http.StatusOK, upload(existing, "&mode=0640").StatusCode)
+	assert.Equal(t, os.FileMode(0o640), mode(existing))
+
+	created := filepath.Join(root, "created")
+	require.Equal(t, http.StatusOK, upload(created, "").StatusCode)
+	assert.Equal(t, os.FileMode(0o644), mode(created))
+
+	nested := filepath.Join(root, "missing", "file")
+	assert.Equal(t, http.StatusInternalServerError, upload(nested, "").StatusCode)
+	require.Equal(t, http.StatusOK, upload(nested, "&create_dirs=true").StatusCode)
+	assert.FileExists(t, nested)
+}
@@ -1,22 +1,60 @@
 package api
 
 import (
+	"encoding/json"
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/scylladb/sct-agent/internal/audit"
+	"github.com/scylladb/sct-agent/internal/auth"
+	"github.com/scylladb/sct-agent/internal/metrics"
+	"github.com/scylladb/sct-agent/internal/storage"
 )
 
-// AuthMiddleware provides API key authentication middleware
-func AuthMiddleware(apiKeys []string) gin.HandlerFunc {
+const (
+	identityKey = "identity"
+
+	auditRecordKey     = "audit_record"
+	auditJobIDKey      = "audit_job_id"
+	auditRequestKey    = "audit_request"
+	auditPipelineIDKey = "audit_pipeline_id"
+	auditPipelineKey   = "audit_pipeline"
+	auditErrorKey      = "audit_error"
+)
+
+// auditActions maps the audited routes to their actions, the other routes only read jobs and are not audited
+var auditActions = map[string]string{
+	"POST /api/v1/commands":                 audit.ActionExecute,
+	"DELETE /api/v1/commands/:job_id":       audit.ActionCancel,
+	"POST /api/v1/pipelines":                audit.ActionPipeline,
+	"DELETE /api/v1/pipelines/:pipeline_id": audit.ActionCancel,
+	"GET /api/v1/sessions":                  audit.ActionSession,
+	"POST /api/v1/admin/cleanup":            audit.ActionCleanup,
+	"PUT /api/v1/files":                     audit.ActionUpload,
+	"GET /api/v1/files":                     audit.ActionDownload,
+}
+
+// AuthMiddleware provides API key authentication middleware, storing the identity of the key in the context.
+// Clients presenting a verified TLS certificate mapped to an identity do not need an API key.
+func AuthMiddleware(keyring *auth.Keyring) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.URL.Path == "/health" {
 			c.Next()
 			return
 		}
 
+		if tlsState := c.Request.TLS; tlsState != nil && len(tlsState.VerifiedChains) > 0 {
+			if identity, exists := keyring.LookupCertificate(tlsState.VerifiedChains[0][0]); exists {
+				c.Set(identityKey, identity)
+				c.Next()
+				return
+			}
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -46,11 +84,10 @@ func AuthMiddleware(apiKeys []string) gin.HandlerFunc {
 			return
 		}
 
-		for _, key := range apiKeys {
-			if token == key {
-				c.Next()
-				return
-			}
+		if identity, exists := keyring.Lookup(token); exists {
+			c.Set(identityKey, identity)
+			c.Next()
+			return
 		}
 
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -61,19 +98,179 @@ func AuthMiddleware(apiKeys []string) gin.HandlerFunc {
 	}
 }
 
-// LoggingMiddleware provides request logging middleware
-func LoggingMiddleware() gin.HandlerFunc {
+// identityFrom returns the identity authenticated by AuthMiddleware
+func identityFrom(c *gin.Context) *auth.Identity {
+	if value, exists := c.Get(identityKey); exists {
+		return value.(*auth.Identity)
+	}
+	return nil
+}
+
+// LoggingMiddleware provides request logging middleware, which also records request latency by route
+func LoggingMiddleware(m *metrics.Metrics) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 
 		c.Next()
 
+		latency := time.Since(start)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.ObserveHTTPRequest(c.Request.Method, route, c.Writer.Status(), latency)
+
 		slog.Debug("HTTP request",
 			"method", c.Request.Method,
 			"path", c.Request.URL.Path,
 			"status", c.Writer.Status(),
-			"latency", time.Since(start),
+			"latency", latency,
 			"client_ip", c.ClientIP(),
 		)
 	}
 }
+
+// AuditMiddleware records the audited actions and failed authentications in the audit log once the request
+// is handled, it must run before AuthMiddleware. Handlers add the job ID and the request with setAuditJob and
+// setAuditRequest, or setAuditPipeline and setAuditPipelineRequest for pipelines, long-running handlers record
+// the action early with recordAudit.
+func AuditMiddleware(log *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if log == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		writer := &errorCapture{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		var once sync.Once
+		record := func(status int) {
+			once.Do(func() {
+				identity := identityFrom(c)
+				action, audited := auditActions[c.Request.Method+" "+c.FullPath()]
+				if identity == nil && status == http.StatusUnauthorized {
+					action, audited = audit.ActionAuthFailure, true
+				}
+				if !audited {
+					return
+				}
+
+				event := &storage.AuditEvent{
+					Time:       start,
+					Action:     action,
+					ClientIP:   c.ClientIP(),
+					Method:     c.Request.Method,
+					Path:       c.Request.URL.Path,
+					Status:     status,
+					JobID:      c.GetString(auditJobIDKey),
+					PipelineID: c.GetString(auditPipelineIDKey),
+					Error:      writer.errorMessage(),
+				}
+				if identity != nil {
+					event.Identity = identity.Name
+				}
+				if event.Error == "" {
+					event.Error = c.GetString(auditErrorKey)
+				}
+				if event.JobID == "" {
+					event.JobID = c.Param("job_id")
+				}
+				if event.PipelineID == "" {
+					event.PipelineID = c.Param("pipeline_id")
+				}
+				if action == audit.ActionUpload || action == audit.ActionDownload {
+					event.File = c.Query("path")
+				}
+				if req, exists := c.Get(auditRequestKey); exists {
+					event.Request = req.(*storage.ExecuteRequest)
+				}
+				if req, exists := c.Get(auditPipelineKey); exists {
+					event.Pipeline = req.(*storage.PipelineRequest)
+				}
+				log.Record(event)
+			})
+		}
+		c.Set(auditRecordKey, record)
+
+		c.Next()
+
+		record(c.Writer.Status())
+	}
+}
+
+// setAuditJob sets the job ID recorded in the audit log
+func setAuditJob(c *gin.Context, jobID string) {
+	c.Set(auditJobIDKey, jobID)
+}
+
+// setAuditRequest sets the execute request recorded in the audit log, it must already be masked
+func setAuditRequest(c *gin.Context, req *storage.ExecuteRequest) {
+	c.Set(auditRequestKey, req)
+}
+
+// setAuditPipeline sets the pipeline ID recorded in the audit log
+func setAuditPipeline(c *gin.Context, pipelineID string) {
+	c.Set(auditPipelineIDKey, pipelineID)
+}
+
+// setAuditPipelineRequest sets the pipeline request recorded in the audit log, it must already be masked
+func setAuditPipelineRequest(c *gin.Context, req *storage.PipelineRequest) {
+	c.Set(auditPipelineKey, req)
+}
+
+// setAuditError sets the error recorded in the audit log of requests which cannot respond with an error, such
+// as rejected sessions
+func setAuditError(c *gin.Context, message string) {
+	c.Set(auditErrorKey, message)
+}
+
+// recordAudit records the action with the given status right away instead of once the request is handled
+func recordAudit(c *gin.Context, status int) {
+	if record, exists := c.Get(auditRecordKey); exists {
+		record.(func(int))(status)
+	}
+}
+
+// maxCapturedError limits the error response kept for the audit log
+const maxCapturedError = 4096
+
+// errorCapture keeps the body of error responses, which carries the error message for the audit log
+type errorCapture struct {
+	gin.ResponseWriter
+	body []byte
+}
+
+func (w *errorCapture) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorCapture) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying connection
+func (w *errorCapture) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *errorCapture) capture(data []byte) {
+	if w.Status() >= http.StatusBadRequest && len(w.body) < maxCapturedError {
+		w.body = append(w.body, data[:min(len(data), maxCapturedError-len(w.body))]...)
+	}
+}
+
+// errorMessage returns the message of a storage.ErrorResponse written as the response, if any
+func (w *errorCapture) errorMessage() string {
+	var response storage.ErrorResponse
+	if len(w.body) == 0 || json.Unmarshal(w.body, &response) != nil {
+		return ""
+	}
+	if response.Message != "" {
+		return response.Message
+	}
+	return response.Error
+}
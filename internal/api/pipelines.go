@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scylladb/sct-agent/internal/executor"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// handles POST /api/v1/pipelines
+//
+// Every step is an execute request as for POST /api/v1/commands, without multipart stdin, request_id and wait,
+// and is authorized by the key policy. The response is the pipeline, whose steps become jobs as they start.
+func (s *Server) executePipeline(c *gin.Context) {
+	var req storage.PipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Invalid request format",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	audited := req
+	audited.Steps = make([]storage.PipelineStep, len(req.Steps))
+	for i, step := range req.Steps {
+		audited.Steps[i] = step
+		audited.Steps[i].ExecuteRequest = s.auditedRequest(&step.ExecuteRequest)
+	}
+	setAuditPipelineRequest(c, &audited)
+
+	identity := identityFrom(c)
+	for i := range req.Steps {
+		step := &req.Steps[i]
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("step-%d", i+1)
+		}
+		if step.Wait {
+			c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+				Error:   "Invalid request",
+				Message: fmt.Sprintf("step %q: wait is not supported in pipelines", name),
+			})
+			return
+		}
+		if err := identity.Policy.AuthorizeExecute(&step.ExecuteRequest); err != nil {
+			c.JSON(http.StatusForbidden, storage.ErrorResponse{
+				Error:   "Command not allowed",
+				Message: fmt.Sprintf("step %q: %v", name, err),
+			})
+			return
+		}
+		if err := s.checkStdinSize(&step.ExecuteRequest); err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, storage.ErrorResponse{
+				Error:   "Invalid stdin",
+				Message: fmt.Sprintf("step %q: %v", name, err),
+			})
+			return
+		}
+	}
+	req.SubmittedBy = identity.Name
+
+	pipeline, err := s.executor.ExecutePipeline(&req)
+	if errors.Is(err, executor.ErrQueueFull) {
+		tooManyRequests(c, queueFullRetryAfter, storage.ErrorResponse{
+			Error:   "Queue full",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, executor.ErrInvalidRequest) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Pipeline failed",
+			Message: err.Error(),
+		})
+		return
+	}
+	setAuditPipeline(c, pipeline.ID)
+
+	c.JSON(http.StatusOK, pipeline)
+}
+
+// handles GET /api/v1/pipelines/{pipeline_id}
+func (s *Server) getPipeline(c *gin.Context) {
+	if pipeline, ok := s.accessiblePipeline(c, c.Param("pipeline_id")); ok {
+		c.JSON(http.StatusOK, pipeline)
+	}
+}
+
+// handles DELETE /api/v1/pipelines/{pipeline_id}
+//
+// Cancels the running steps, the steps which did not start yet are never run.
+func (s *Server) cancelPipeline(c *gin.Context) {
+	pipelineID := c.Param("pipeline_id")
+	if _, ok := s.accessiblePipeline(c, pipelineID); !ok {
+		return
+	}
+
+	if err := identityFrom(c).Policy.AuthorizeWrite(); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Cannot cancel pipeline",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := s.executor.CancelPipeline(pipelineID); err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Cannot cancel pipeline",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pipeline_id": pipelineID,
+		"status":      "cancelled",
+		"message":     "Pipeline cancelled successfully",
+	})
+}
+
+// accessiblePipeline returns the pipeline if it exists and the caller is allowed to access it,
+// otherwise it responds with 404 Not Found
+func (s *Server) accessiblePipeline(c *gin.Context, pipelineID string) (*storage.Pipeline, bool) {
+	pipeline, err := s.executor.GetPipeline(pipelineID)
+	if err != nil || !identityFrom(c).CanAccessPipeline(pipeline) {
+		c.JSON(http.StatusNotFound, storage.ErrorResponse{
+			Error:   "Pipeline not found",
+			Message: fmt.Sprintf("Pipeline with ID %s not found", pipelineID),
+		})
+		return nil, false
+	}
+	return pipeline, true
+}
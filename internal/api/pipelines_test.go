@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/auth"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// pipelineStep returns a step running echo
+func pipelineStep(name string, dependsOn ...string) storage.PipelineStep {
+	return storage.PipelineStep{
+		Name:           name,
+		DependsOn:      dependsOn,
+		ExecuteRequest: storage.ExecuteRequest{Command: "echo", Args: []string{name}},
+	}
+}
+
+func TestExecutePipeline(t *testing.T) {
+	server, _ := newTestServer(t, Config{})
+
+	resp := postJSON(t, server, "/api/v1/pipelines", adminKey, &storage.PipelineRequest{
+		Name:  "deploy",
+		Steps: []storage.PipelineStep{pipelineStep("build"), pipelineStep("test", "build"), pipelineStep("lint", "build")},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	pipeline := decode[storage.Pipeline](t, resp)
+	assert.NotEmpty(t, pipeline.ID)
+	require.Len(t, pipeline.Steps, 3)
+	assert.Equal(t, []string{"build"}, pipeline.Steps[2].DependsOn)
+
+	resp = doRequest(t, server, http.MethodGet, "/api/v1/pipelines/"+pipeline.ID, adminKey, "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestExecutePipelineInvalid(t *testing.T) {
+	server, e := newTestServer(t, Config{},
+		auth.Key{Name: "echo", Key: "echo-key", Policy: auth.Policy{AllowedCommands: []string{"echo"}}})
+
+	for name, test := range map[string]struct {
+		key    string
+		steps  []storage.PipelineStep
+		status int
+		error  string
+	}{
+		"cycle": {
+			key:    adminKey,
+			steps:  []storage.PipelineStep{pipelineStep("a", "c"), pipelineStep("b", "a"), pipelineStep("c", "b")},
+			status: http.StatusBadRequest,
+			error:  "form a cycle",
+		},
+		"self dependency": {
+			key:    adminKey,
+			steps:  []storage.PipelineStep{pipelineStep("a", "a")},
+			status: http.StatusBadRequest,
+			error:  "depends on itself",
+		},
+		"unknown dependency": {
+			key:    adminKey,
+			steps:  []storage.PipelineStep{pipelineStep("a"), pipelineStep("b", "missing")},
+			status: http.StatusBadRequest,
+			error:  `depends on unknown step "missing"`,
+		},
+		"forbidden step": {
+			key: "echo-key",
+			steps: []storage.PipelineStep{pipelineStep("a"), {
+				Name:           "b",
+				ExecuteRequest: storage.ExecuteRequest{Command: "rm", Args: []string{"-rf", "/tmp/x"}},
+			}},
+			status: http.StatusForbidden,
+			error:  `step "b"`,
+		},
+	} {
+		resp := postJSON(t, server, "/api/v1/pipelines", test.key, &storage.PipelineRequest{Steps: test.steps})
+		assert.Equal(t, test.status, resp.StatusCode, name)
+		assert.Contains(t, decode[storage.ErrorResponse](t, resp).Message, test.error, name)
+	}
+
+	jobs, total, err := e.ListJobs("", 10, 0, nil)
+	require.NoError(t, err)
+	assert.Zero(t, total, "no step of a rejected pipeline is submitted")
+	assert.Empty(t, jobs)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// RateLimit is a token bucket refilled with RequestsPerSecond tokens up to Burst, a zero rate does not limit
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+func (r RateLimit) limiter() *rate.Limiter {
+	if r.RequestsPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(r.RequestsPerSecond), max(r.Burst, 1))
+}
+
+// rateLimiter admits requests within both the global limit and the limit of their key
+type rateLimiter struct {
+	global *rate.Limiter
+	perKey RateLimit
+
+	mutex sync.Mutex
+	keys  map[string]*rate.Limiter
+}
+
+func newRateLimiter(global, perKey RateLimit) *rateLimiter {
+	return &rateLimiter{
+		global: global.limiter(),
+		perKey: perKey,
+		keys:   make(map[string]*rate.Limiter),
+	}
+}
+
+// reserve takes a token from the bucket of the key and the global one, it returns how long to wait before
+// retrying if either is empty, in which case no token is taken
+func (l *rateLimiter) reserve(key string, now time.Time) time.Duration {
+	var reservations []*rate.Reservation
+	for _, limiter := range []*rate.Limiter{l.keyLimiter(key), l.global} {
+		if limiter == nil {
+			continue
+		}
+		reservation := limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			for _, taken := range reservations {
+				taken.CancelAt(now)
+			}
+			return delay
+		}
+		reservations = append(reservations, reservation)
+	}
+	return 0
+}
+
+func (l *rateLimiter) keyLimiter(key string) *rate.Limiter {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limiter, exists := l.keys[key]
+	if !exists {
+		limiter = l.perKey.limiter()
+		l.keys[key] = limiter
+	}
+	return limiter
+}
+
+// RateLimitMiddleware rejects requests exceeding the rate limits with 429 Too Many Requests and a Retry-After
+// header, it must run after AuthMiddleware
+func RateLimitMiddleware(global, perKey RateLimit) gin.HandlerFunc {
+	limiter := newRateLimiter(global, perKey)
+	return func(c *gin.Context) {
+		delay := limiter.reserve(identityFrom(c).Name, time.Now())
+		if delay == 0 {
+			c.Next()
+			return
+		}
+
+		tooManyRequests(c, delay, storage.ErrorResponse{
+			Error:   "Rate limit exceeded",
+			Message: "Too many requests, retry after " + delay.Round(time.Millisecond).String(),
+		})
+		c.Abort()
+	}
+}
+
+// tooManyRequests responds with 429 Too Many Requests, telling the client to retry after delay
+func tooManyRequests(c *gin.Context, delay time.Duration, response storage.ErrorResponse) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, response)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{RequestsPerSecond: 10, Burst: 3}, RateLimit{RequestsPerSecond: 1, Burst: 2})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Zero(t, limiter.reserve("a", now))
+	assert.Zero(t, limiter.reserve("a", now))
+	assert.Equal(t, time.Second, limiter.reserve("a", now), "the bucket of the key is empty")
+
+	assert.Zero(t, limiter.reserve("b", now))
+	delay := limiter.reserve("b", now)
+	assert.Positive(t, delay, "the global bucket is empty")
+	assert.Less(t, delay, time.Second)
+
+	// rejected requests do not take tokens, so b is admitted once the global bucket refills
+	assert.Zero(t, limiter.reserve("b", now.Add(delay)))
+	assert.Zero(t, limiter.reserve("a", now.Add(time.Second)))
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{}, RateLimit{RequestsPerSecond: 1})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Zero(t, limiter.reserve("a", now))
+	assert.Zero(t, limiter.reserve("b", now), "keys have their own buckets and there is no global limit")
+	assert.Positive(t, limiter.reserve("a", now))
+}
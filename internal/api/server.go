@@ -1,30 +1,80 @@
 package api
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/scylladb/sct-agent/internal/audit"
+	"github.com/scylladb/sct-agent/internal/auth"
 	"github.com/scylladb/sct-agent/internal/executor"
+	"github.com/scylladb/sct-agent/internal/health"
+	"github.com/scylladb/sct-agent/internal/metrics"
 	"github.com/scylladb/sct-agent/internal/storage"
 )
 
+const (
+	// maxWait limits how long a request can wait for a job to finish
+	maxWait    = 5 * time.Minute
+	waitHeader = "X-SCT-Wait"
+	// defaultExecuteWait is how long a synchronous execute request waits for the job by default
+	defaultExecuteWait = 30 * time.Second
+	// queueFullRetryAfter is how long clients are asked to wait when the job queue is full
+	queueFullRetryAfter = 10 * time.Second
+	// idempotencyKeyHeader sets the request ID of an execute request, replayedHeader marks responses returning
+	// the job of an earlier request with the same request ID
+	idempotencyKeyHeader = "Idempotency-Key"
+	replayedHeader       = "Idempotent-Replayed"
+	// idempotencyWindowHeader tells clients that submissions are deduplicated and for how many seconds
+	idempotencyWindowHeader = "Idempotency-Window"
+)
+
+// errStdinTooLarge is returned for execute requests whose stdin exceeds Config.MaxStdinBytes
+var errStdinTooLarge = errors.New("stdin too large")
+
+// Config holds the API server settings
+type Config struct {
+	Keyring *auth.Keyring
+	Version string
+	// WritableRoots lists directories under which files can be uploaded
+	WritableRoots []string
+	// ReadableRoots lists directories under which files can be downloaded by restricted keys
+	ReadableRoots []string
+	// MaxStdinBytes limits the stdin of execute requests, inline or streamed, 0 disables the limit
+	MaxStdinBytes int64
+	// Metrics enables the /metrics endpoint and HTTP request metrics, may be nil
+	Metrics *metrics.Metrics
+	// Health provides the health checks reported by /health and /readyz, the agent is always reported
+	// as healthy if nil
+	Health *health.Checker
+	// Audit records API actions and enables GET /api/v1/audit, may be nil
+	Audit *audit.Log
+	// GlobalRateLimit limits the API requests of all keys together, KeyRateLimit those of every key
+	GlobalRateLimit RateLimit
+	KeyRateLimit    RateLimit
+}
+
 type Server struct {
 	executor  *executor.Executor
-	apiKeys   []string
-	version   string
+	config    Config
 	startTime time.Time
 }
 
-func New(executor *executor.Executor, apiKeys []string, version string) *Server {
+func New(executor *executor.Executor, config Config) *Server {
 	return &Server{
 		executor:  executor,
-		apiKeys:   apiKeys,
-		version:   version,
+		config:    config,
 		startTime: time.Now(),
 	}
 }
@@ -33,26 +83,81 @@ func (s *Server) SetupRoutes() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.New()
-	r.Use(gin.Recovery(), LoggingMiddleware())
+	r.Use(gin.Recovery(), LoggingMiddleware(s.config.Metrics))
 
 	r.GET("/health", s.healthHandler)
+	r.GET("/livez", s.livenessHandler)
+	r.GET("/readyz", s.readinessHandler)
+	if s.config.Metrics != nil {
+		r.GET("/metrics", gin.WrapH(s.config.Metrics.Handler()))
+	}
 
-	protected := r.Group("/", AuthMiddleware(s.apiKeys))
+	protected := r.Group("/", AuditMiddleware(s.config.Audit), AuthMiddleware(s.config.Keyring))
 	api := protected.Group("/api/v1")
+	if s.config.GlobalRateLimit.RequestsPerSecond > 0 || s.config.KeyRateLimit.RequestsPerSecond > 0 {
+		api.Use(RateLimitMiddleware(s.config.GlobalRateLimit, s.config.KeyRateLimit))
+	}
 	{
 		api.POST("/commands", s.executeCommand)
 		api.GET("/commands/:job_id", s.getCommand)
+		api.GET("/commands/:job_id/stream", s.streamCommand)
+		api.GET("/commands/:job_id/output", s.getCommandOutput)
 		api.GET("/commands", s.listCommands)
 		api.DELETE("/commands/:job_id", s.cancelCommand)
+
+		api.POST("/pipelines", s.executePipeline)
+		api.GET("/pipelines/:pipeline_id", s.getPipeline)
+		api.DELETE("/pipelines/:pipeline_id", s.cancelPipeline)
+
+		api.GET("/sessions", s.openSession)
+
+		api.POST("/admin/cleanup", s.cleanupJobs)
+		api.GET("/audit", s.getAuditLog)
+
+		api.PUT("/files", s.uploadFile)
+		api.GET("/files", s.downloadFile)
 	}
 
 	return r
 }
 
+// handles POST /api/v1/commands
+//
+// The request is either a JSON document or, for large stdin payloads, a multipart/form-data body with
+// a "request" part holding the JSON document and a "stdin" part streamed into a temporary file. The request
+// part has to come first, the stdin part is only read once the request is authorized. Stdin larger than
+// Config.MaxStdinBytes is rejected with 413 Request Entity Too Large.
+//
+// A request with a request ID, set by request_id or the Idempotency-Key header, which the same key already
+// submitted within the idempotency window returns the existing job with the Idempotent-Replayed header.
+//
+// With "wait": true, the response is delayed until the job finishes and the whole job is returned. If the job
+// does not finish within max_wait seconds (defaultExecuteWait if not set, at most maxWait), the usual response
+// is returned with 202 Accepted instead.
+//
+// When deduplication is enabled, every response carries the Idempotency-Window header, which tells clients that
+// retrying a submission with the same request ID is safe.
 func (s *Server) executeCommand(c *gin.Context) {
+	if window := s.executor.IdempotencyWindow(); window > 0 {
+		c.Header(idempotencyWindowHeader, strconv.Itoa(int(window.Seconds())))
+	}
+
 	var req storage.ExecuteRequest
 
-	if err := c.ShouldBindJSON(&req); err != nil {
+	// the stdin part of a multipart request is only read once the request is authorized
+	var parts *multipart.Reader
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		// large stdin uploads may outlive the server read timeout
+		_ = http.NewResponseController(c.Writer).SetReadDeadline(time.Time{})
+		var err error
+		if parts, err = readMultipartRequest(c, &req); err != nil {
+			c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+				Error:   "Invalid request format",
+				Message: err.Error(),
+			})
+			return
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
 			Error:   "Invalid request format",
 			Message: err.Error(),
@@ -68,25 +173,216 @@ func (s *Server) executeCommand(c *gin.Context) {
 		return
 	}
 
+	if key := c.GetHeader(idempotencyKeyHeader); key != "" {
+		if req.RequestID != "" && req.RequestID != key {
+			c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+				Error:   "Invalid request",
+				Message: "request_id differs from the " + idempotencyKeyHeader + " header",
+			})
+			return
+		}
+		req.RequestID = key
+	}
+
+	if req.MaxWait < 0 {
+		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Invalid request",
+			Message: "max_wait must not be negative",
+		})
+		return
+	}
+
+	s.setAuditRequest(c, &req)
+
+	identity := identityFrom(c)
+	if err := identity.Policy.AuthorizeExecute(&req); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Command not allowed",
+			Message: err.Error(),
+		})
+		return
+	}
+	req.SubmittedBy = identity.Name
+
+	var err error
+	if parts != nil {
+		err = s.receiveStdin(parts, &req)
+	} else {
+		err = s.checkStdinSize(&req)
+	}
+	if err != nil {
+		removeStdinFile(&req)
+		status := http.StatusBadRequest
+		if errors.Is(err, errStdinTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Invalid stdin",
+			Message: err.Error(),
+		})
+		return
+	}
+
 	job, err := s.executor.Execute(&req)
+	replayed := errors.Is(err, executor.ErrDuplicateRequest)
+	if replayed {
+		// the existing job has its own stdin
+		removeStdinFile(&req)
+		c.Header(replayedHeader, "true")
+		err = nil
+	}
+	if errors.Is(err, executor.ErrQueueFull) {
+		removeStdinFile(&req)
+		tooManyRequests(c, queueFullRetryAfter, storage.ErrorResponse{
+			Error:   "Queue full",
+			Message: err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, storage.ErrorResponse{
+		removeStdinFile(&req)
+		status := http.StatusInternalServerError
+		if errors.Is(err, executor.ErrInvalidRequest) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, storage.ErrorResponse{
 			Error:   "Execution failed",
 			Message: err.Error(),
 		})
 		return
 	}
+	setAuditJob(c, job.ID)
+
+	if req.Wait {
+		wait := defaultExecuteWait
+		if req.MaxWait > 0 {
+			wait = min(time.Duration(req.MaxWait)*time.Second, maxWait)
+		}
 
+		// the wait may outlive the server write timeout
+		_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		defer cancel()
+		if finished, err := s.executor.WaitJob(ctx, job.ID); err == nil && finished.Status.IsTerminal() {
+			c.JSON(http.StatusOK, finished)
+			return
+		}
+		// the job has most likely started meanwhile
+		if current, err := s.executor.GetJob(job.ID); err == nil {
+			job = current
+		}
+
+		c.JSON(http.StatusAccepted, storage.ExecuteResponse{
+			JobID:     job.ID,
+			Status:    job.Status,
+			CreatedAt: job.CreatedAt,
+			Command:   job.Command,
+			Message:   fmt.Sprintf("Command did not finish within %v", wait),
+		})
+		return
+	}
+
+	message := "Command queued successfully"
+	if replayed {
+		message = "Command already submitted with this request ID"
+	}
 	c.JSON(http.StatusOK, storage.ExecuteResponse{
 		JobID:     job.ID,
 		Status:    job.Status,
 		CreatedAt: job.CreatedAt,
 		Command:   job.Command,
-		Message:   "Command queued successfully",
+		Message:   message,
 	})
 }
 
+// readMultipartRequest decodes the "request" part, which has to come first, and returns the reader positioned
+// at the following parts
+func readMultipartRequest(c *gin.Context, req *storage.ExecuteRequest) (*multipart.Reader, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := reader.NextPart()
+	if err == io.EOF || (err == nil && part.FormName() != "request") {
+		return nil, fmt.Errorf("the request part must come first")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+	if err := json.NewDecoder(part).Decode(req); err != nil {
+		return nil, fmt.Errorf("invalid request part: %w", err)
+	}
+	return reader, nil
+}
+
+// receiveStdin streams the "stdin" part of an authorized multipart request into a temporary file, which is
+// set as req.StdinFile
+func (s *Server) receiveStdin(reader *multipart.Reader, req *storage.ExecuteRequest) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if part.FormName() != "stdin" {
+			part.Close()
+			continue
+		}
+		if req.StdinFile != "" {
+			return fmt.Errorf("more than one stdin part")
+		}
+
+		file, err := os.CreateTemp("", "sct-agent-stdin-*")
+		if err != nil {
+			return fmt.Errorf("failed to create stdin file: %w", err)
+		}
+		req.StdinFile = file.Name()
+		var src io.Reader = part
+		if s.config.MaxStdinBytes > 0 {
+			src = io.LimitReader(part, s.config.MaxStdinBytes+1)
+		}
+		n, err := io.Copy(file, src)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		part.Close()
+		if err != nil {
+			return fmt.Errorf("failed to receive stdin: %w", err)
+		}
+		if s.config.MaxStdinBytes > 0 && n > s.config.MaxStdinBytes {
+			return fmt.Errorf("%w: stdin exceeds %d bytes", errStdinTooLarge, s.config.MaxStdinBytes)
+		}
+	}
+}
+
+// checkStdinSize checks the inline stdin of req against the stdin limit
+func (s *Server) checkStdinSize(req *storage.ExecuteRequest) error {
+	size := int64(len(req.Stdin))
+	if req.StdinEncoding == executor.StdinEncodingBase64 {
+		size = int64(base64.StdEncoding.DecodedLen(len(req.Stdin)) - strings.Count(req.Stdin[max(len(req.Stdin)-2, 0):], "="))
+	}
+	if s.config.MaxStdinBytes > 0 && size > s.config.MaxStdinBytes {
+		return fmt.Errorf("%w: stdin exceeds %d bytes", errStdinTooLarge, s.config.MaxStdinBytes)
+	}
+	return nil
+}
+
+func removeStdinFile(req *storage.ExecuteRequest) {
+	if req.StdinFile != "" {
+		os.Remove(req.StdinFile)
+	}
+}
+
 // handles GET /api/v1/commands/{job_id}
+//
+// With the wait query parameter (a duration such as "30s" or a number of seconds, at most maxWait), the
+// response is delayed until the job reaches a terminal state or the wait expires. The applied wait is
+// returned in the X-SCT-Wait header, so that clients can tell the parameter is supported.
 func (s *Server) getCommand(c *gin.Context) {
 	jobID := c.Param("job_id")
 	if jobID == "" {
@@ -97,14 +393,164 @@ func (s *Server) getCommand(c *gin.Context) {
 		return
 	}
 
-	if job, err := s.executor.GetJob(jobID); err != nil {
+	wait, err := parseWait(c.Query("wait"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Invalid wait parameter",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	job, ok := s.accessibleJob(c, jobID)
+	if !ok {
+		return
+	}
+
+	if wait > 0 && !job.Status.IsTerminal() {
+		// the wait may outlive the server write timeout
+		_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), wait)
+		defer cancel()
+		if job, err = s.executor.WaitJob(ctx, jobID); err != nil {
+			c.JSON(http.StatusNotFound, storage.ErrorResponse{
+				Error:   "Job not found",
+				Message: fmt.Sprintf("Job with ID %s not found", jobID),
+			})
+			return
+		}
+	}
+
+	c.Header(waitHeader, wait.String())
+	c.JSON(http.StatusOK, job)
+}
+
+// parseWait parses the wait query parameter, an empty value means no wait
+func parseWait(param string) (time.Duration, error) {
+	if param == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(param)
+	if err != nil {
+		seconds, atoiErr := strconv.Atoi(param)
+		if atoiErr != nil {
+			return 0, fmt.Errorf("wait must be a duration, e.g. 30s, or a number of seconds, got %q", param)
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		return 0, fmt.Errorf("wait must not be negative")
+	}
+	return min(wait, maxWait), nil
+}
+
+// accessibleJob returns the job if it exists and the caller is allowed to access it,
+// otherwise it responds with 404 Not Found
+func (s *Server) accessibleJob(c *gin.Context, jobID string) (*storage.Job, bool) {
+	job, err := s.executor.GetJob(jobID)
+	if err != nil || !identityFrom(c).CanAccess(job) {
+		c.JSON(http.StatusNotFound, storage.ErrorResponse{
+			Error:   "Job not found",
+			Message: fmt.Sprintf("Job with ID %s not found", jobID),
+		})
+		return nil, false
+	}
+	return job, true
+}
+
+// handles GET /api/v1/commands/{job_id}/stream
+//
+// Output is sent as Server-Sent Events named "stdout" and "stderr". The stream is closed with a
+// "status" event carrying the final job. Clients can resume by passing the last seen next_offset
+// values via stdout_offset and stderr_offset query parameters.
+func (s *Server) streamCommand(c *gin.Context) {
+	jobID := c.Param("job_id")
+	stdoutOffset := int64(parseQueryParam(c.Query("stdout_offset"), 0, -1))
+	stderrOffset := int64(parseQueryParam(c.Query("stderr_offset"), 0, -1))
+
+	if _, ok := s.accessibleJob(c, jobID); !ok {
+		return
+	}
+
+	chunks, err := s.executor.FollowOutput(c.Request.Context(), jobID, stdoutOffset, stderrOffset)
+	if err != nil {
 		c.JSON(http.StatusNotFound, storage.ErrorResponse{
 			Error:   "Job not found",
 			Message: fmt.Sprintf("Job with ID %s not found", jobID),
 		})
-	} else {
-		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	// streams may outlive the server write timeout
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				if c.Request.Context().Err() != nil {
+					return
+				}
+				if job, err := s.executor.GetJob(jobID); err == nil {
+					c.SSEvent("status", job)
+					c.Writer.Flush()
+				}
+				return
+			}
+			c.SSEvent(chunk.Stream, storage.OutputEvent{
+				Offset:     chunk.Offset,
+				NextOffset: chunk.Offset + int64(len(chunk.Data)),
+				Data:       string(chunk.Data),
+			})
+			c.Writer.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// handles GET /api/v1/commands/{job_id}/output
+//
+// Returns a raw byte range of the job's stdout or stderr, including output which did not fit into
+// the in-memory capture limit. X-Output-Total-Bytes tells the total size of the stream.
+func (s *Server) getCommandOutput(c *gin.Context) {
+	jobID := c.Param("job_id")
+	stream := c.DefaultQuery("stream", executor.StreamStdout)
+	offset := int64(parseQueryParam(c.Query("offset"), 0, -1))
+	length := int64(parseQueryParam(c.DefaultQuery("length", "1048576"), 1<<20, 16<<20))
+
+	if _, ok := s.accessibleJob(c, jobID); !ok {
+		return
+	}
+
+	data, total, err := s.executor.ReadOutput(jobID, stream, offset, length)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "not found") {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, storage.ErrorResponse{
+			Error:   "Cannot read job output",
+			Message: err.Error(),
+		})
+		return
 	}
+
+	c.Header("X-Output-Offset", strconv.FormatInt(offset, 10))
+	c.Header("X-Output-Total-Bytes", strconv.FormatInt(total, 10))
+	c.Data(http.StatusOK, "application/octet-stream", data)
 }
 
 func parseQueryParam(param string, defaultValue, maxValue int) int {
@@ -135,7 +581,15 @@ func (s *Server) listCommands(c *gin.Context) {
 		}
 	}
 
-	jobs, total, err := s.executor.ListJobs(status, limit, offset, since)
+	identity := identityFrom(c)
+	var jobs []*storage.Job
+	var total int
+	var err error
+	if identity.Policy.OwnJobsOnly {
+		jobs, total, err = s.listOwnJobs(identity, status, limit, offset, since)
+	} else {
+		jobs, total, err = s.executor.ListJobs(status, limit, offset, since)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, storage.ErrorResponse{
 			Error:   "Failed to list jobs",
@@ -144,9 +598,16 @@ func (s *Server) listCommands(c *gin.Context) {
 		return
 	}
 
+	// output bodies can be large, they are only included on request
+	includeOutput := c.Query("include_output") == "true"
+
 	jobList := make([]storage.Job, len(jobs))
 	for i, job := range jobs {
 		jobList[i] = *job
+		if !includeOutput {
+			jobList[i].Stdout = ""
+			jobList[i].Stderr = ""
+		}
 	}
 
 	c.JSON(http.StatusOK, storage.JobListResponse{
@@ -157,6 +618,31 @@ func (s *Server) listCommands(c *gin.Context) {
 	})
 }
 
+// listOwnJobs lists only jobs submitted by the identity, paginating after filtering
+func (s *Server) listOwnJobs(identity *auth.Identity, status storage.JobStatus, limit, offset int, since *time.Time) ([]*storage.Job, int, error) {
+	all, _, err := s.executor.ListJobs(status, 0, 0, since)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var own []*storage.Job
+	for _, job := range all {
+		if identity.CanAccess(job) {
+			own = append(own, job)
+		}
+	}
+
+	total := len(own)
+	if offset >= total {
+		return []*storage.Job{}, total, nil
+	}
+	own = own[offset:]
+	if limit > 0 && len(own) > limit {
+		own = own[:limit]
+	}
+	return own, total, nil
+}
+
 // handles DELETE /api/v1/commands/{job_id}
 func (s *Server) cancelCommand(c *gin.Context) {
 	jobID := c.Param("job_id")
@@ -168,6 +654,18 @@ func (s *Server) cancelCommand(c *gin.Context) {
 		return
 	}
 
+	if _, ok := s.accessibleJob(c, jobID); !ok {
+		return
+	}
+
+	if err := identityFrom(c).Policy.AuthorizeWrite(); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Cannot cancel job",
+			Message: err.Error(),
+		})
+		return
+	}
+
 	if err := s.executor.CancelJob(jobID); err != nil {
 		status := http.StatusBadRequest
 		if strings.Contains(err.Error(), "not found") {
@@ -187,20 +685,105 @@ func (s *Server) cancelCommand(c *gin.Context) {
 	})
 }
 
+// handles POST /api/v1/admin/cleanup
+//
+// Prunes finished jobs according to the retention policy right away instead of at the next periodic cleanup.
+func (s *Server) cleanupJobs(c *gin.Context) {
+	if err := identityFrom(c).Policy.AuthorizeAdmin(); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Cleanup not allowed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	pruned := s.executor.Cleanup()
+	c.JSON(http.StatusOK, storage.CleanupResponse{
+		Pruned:    pruned,
+		Remaining: s.executor.GetStats()["total"],
+	})
+}
+
+// handles GET /api/v1/audit
+//
+// Returns the audit events between the optional since and until query parameters (RFC 3339), oldest first,
+// at most limit (1000 by default). Requires a key without any restriction.
+func (s *Server) getAuditLog(c *gin.Context) {
+	if err := identityFrom(c).Policy.AuthorizeAdmin(); err != nil {
+		c.JSON(http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Audit log not allowed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if s.config.Audit == nil {
+		c.JSON(http.StatusNotFound, storage.ErrorResponse{
+			Error:   "Audit log not enabled",
+			Message: "The agent is configured without an audit log",
+		})
+		return
+	}
+
+	var bounds [2]*time.Time
+	for i, name := range []string{"since", "until"} {
+		param := c.Query(name)
+		if param == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+				Error:   "Invalid time range",
+				Message: fmt.Sprintf("%s must be an RFC 3339 time, got %q", name, param),
+			})
+			return
+		}
+		bounds[i] = &t
+	}
+	limit := parseQueryParam(c.DefaultQuery("limit", "1000"), 1000, 10000)
+
+	events, truncated, err := s.config.Audit.Query(bounds[0], bounds[1], limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, storage.ErrorResponse{
+			Error:   "Failed to read audit log",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, storage.AuditResponse{Events: events, Truncated: truncated})
+}
+
+// setAuditRequest sets a copy of the request for the audit log, with the values of sensitive variables
+// masked and without stdin and callback credentials
+func (s *Server) setAuditRequest(c *gin.Context, req *storage.ExecuteRequest) {
+	audited := s.auditedRequest(req)
+	setAuditRequest(c, &audited)
+}
+
+func (s *Server) auditedRequest(req *storage.ExecuteRequest) storage.ExecuteRequest {
+	audited := *req
+	audited.Env = s.executor.MaskEnv(req.Env)
+	audited.Stdin = ""
+	if req.Callback != nil {
+		audited.Callback = &storage.Callback{URL: req.Callback.URL}
+	}
+	return audited
+}
+
 // handles GET /health
+//
+// Responds with 503 Service Unavailable when the agent is unhealthy, a degraded agent still serves requests.
 func (s *Server) healthHandler(c *gin.Context) {
 	stats := s.executor.GetStats()
 
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
-	// TODO: MVP purposes always returns "healthy". Later actual health checks can be implemented:
-	// - memory thresholds
-	// - executor capacity (e.g. degradation if at max concurrent jobs)
-	// - etc
 	response := storage.HealthResponse{
-		Status:        "healthy",
-		Version:       s.version,
+		Status:        health.StatusHealthy,
+		Version:       s.config.Version,
 		UptimeSeconds: int64(time.Since(s.startTime).Seconds()),
 		RunningJobs:   stats["running"],
 		CompletedJobs: stats["completed"],
@@ -209,5 +792,41 @@ func (s *Server) healthHandler(c *gin.Context) {
 		},
 	}
 
-	c.JSON(http.StatusOK, response)
+	if s.config.Health != nil {
+		report := s.config.Health.Last(c.Request.Context())
+		response.Status = report.Status
+		response.Checks = report.Checks
+	}
+
+	c.JSON(healthStatusCode(response.Status), response)
+}
+
+// handles GET /livez
+//
+// The agent is alive as long as it serves HTTP requests.
+func (s *Server) livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// handles GET /readyz
+//
+// The agent is ready to accept jobs unless it is unhealthy.
+func (s *Server) readinessHandler(c *gin.Context) {
+	status := health.StatusHealthy
+	if s.config.Health != nil {
+		status = s.config.Health.Last(c.Request.Context()).Status
+	}
+
+	if code := healthStatusCode(status); code != http.StatusOK {
+		c.JSON(code, gin.H{"status": "not ready", "health": status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "health": status})
+}
+
+func healthStatusCode(status string) int {
+	if status == health.StatusUnhealthy {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
 }
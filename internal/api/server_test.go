@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/auth"
+	"github.com/scylladb/sct-agent/internal/executor"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// adminKey is the unrestricted key of test servers
+const adminKey = "admin-key"
+
+// newTestServer serves the API with config, the unrestricted adminKey and the given keys
+func newTestServer(t *testing.T, config Config, keys ...auth.Key) (*httptest.Server, *executor.Executor) {
+	t.Helper()
+
+	keyring, err := auth.NewKeyring(append([]auth.Key{{Name: "admin", Key: adminKey}}, keys...))
+	require.NoError(t, err)
+	config.Keyring = keyring
+
+	e := executor.NewExecutor(executor.Config{MaxConcurrentJobs: 2, OutputDir: t.TempDir()}, storage.NewMemory())
+	server := httptest.NewServer(New(e, config).SetupRoutes())
+	t.Cleanup(func() {
+		server.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		e.Shutdown(ctx)
+	})
+	return server, e
+}
+
+// doRequest sends a request authenticated with key
+func doRequest(t *testing.T, server *httptest.Server, method, path, key, contentType string, body io.Reader) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, body)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+key)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// postJSON sends value as JSON, authenticated with key
+func postJSON(t *testing.T, server *httptest.Server, path, key string, value any) *http.Response {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return doRequest(t, server, http.MethodPost, path, key, "application/json", bytes.NewReader(data))
+}
+
+// decode decodes the JSON body of resp
+func decode[T any](t *testing.T, resp *http.Response) *T {
+	t.Helper()
+
+	var value T
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&value))
+	return &value
+}
+
+// stdinForm returns a multipart body with the parts in the given order, "request" holding req
+func stdinForm(t *testing.T, req *storage.ExecuteRequest, stdin io.Reader, stdinFirst bool) (io.Reader, string) {
+	t.Helper()
+
+	data, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writeRequest := func() error {
+			part, err := form.CreateFormField("request")
+			if err == nil {
+				_, err = part.Write(data)
+			}
+			return err
+		}
+		writeStdin := func() error {
+			part, err := form.CreateFormFile("stdin", "stdin")
+			if err == nil {
+				_, err = io.Copy(part, stdin)
+			}
+			return err
+		}
+		first, second := writeRequest, writeStdin
+		if stdinFirst {
+			first, second = writeStdin, writeRequest
+		}
+		err := first()
+		if err == nil {
+			err = second()
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return body, form.FormDataContentType()
+}
+
+func TestExecuteStdin(t *testing.T) {
+	server, _ := newTestServer(t, Config{})
+
+	for name, req := range map[string]*storage.ExecuteRequest{
+		"inline": {Command: "cat", Stdin: "hello", Wait: true},
+		"base64": {Command: "cat", Stdin: "aGVsbG8=", StdinEncoding: executor.StdinEncodingBase64, Wait: true},
+	} {
+		resp := postJSON(t, server, "/api/v1/commands", adminKey, req)
+		require.Equal(t, http.StatusOK, resp.StatusCode, name)
+		job := decode[storage.Job](t, resp)
+		assert.Equal(t, storage.StatusCompleted, job.Status, name)
+		assert.Equal(t, "hello", job.Stdout, name)
+	}
+
+	body, contentType := stdinForm(t, &storage.ExecuteRequest{Command: "cat", Wait: true}, strings.NewReader("hello"), false)
+	resp := doRequest(t, server, http.MethodPost, "/api/v1/commands", adminKey, contentType, body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	job := decode[storage.Job](t, resp)
+	assert.Equal(t, "hello", job.Stdout, "multipart")
+
+	body, contentType = stdinForm(t, &storage.ExecuteRequest{Command: "cat"}, strings.NewReader("hello"), true)
+	resp = doRequest(t, server, http.MethodPost, "/api/v1/commands", adminKey, contentType, body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "the request part has to come first")
+}
+
+func TestExecuteStdinUnauthorized(t *testing.T) {
+	server, _ := newTestServer(t, Config{}, auth.Key{Name: "echo", Key: "echo-key", Policy: auth.Policy{AllowedCommands: []string{"echo"}}})
+	// receiving stdin fails, so the request is only forbidden if it is rejected before stdin is read
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	body, contentType := stdinForm(t, &storage.ExecuteRequest{Command: "cat"}, strings.NewReader("hello"), false)
+	resp := doRequest(t, server, http.MethodPost, "/api/v1/commands", "echo-key", contentType, body)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestExecuteStdinLimit(t *testing.T) {
+	server, _ := newTestServer(t, Config{MaxStdinBytes: 4})
+
+	for name, req := range map[string]*storage.ExecuteRequest{
+		"inline": {Command: "cat", Stdin: "hello"},
+		"base64": {Command: "cat", Stdin: "aGVsbG8=", StdinEncoding: executor.StdinEncodingBase64},
+	} {
+		resp := postJSON(t, server, "/api/v1/commands", adminKey, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, name)
+	}
+
+	resp := postJSON(t, server, "/api/v1/commands", adminKey,
+		&storage.ExecuteRequest{Command: "cat", Stdin: "YWJjZA==", StdinEncoding: executor.StdinEncodingBase64})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the limit applies to the decoded size")
+
+	body, contentType := stdinForm(t, &storage.ExecuteRequest{Command: "cat"}, strings.NewReader("hello"), false)
+	resp = doRequest(t, server, http.MethodPost, "/api/v1/commands", adminKey, contentType, body)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, "multipart")
+}
+
+// sseEvent is a Server-Sent Event read by readEvents
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents reads the events of a stream until it is closed
+func readEvents(t *testing.T, resp *http.Response) []sseEvent {
+	t.Helper()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var events []sseEvent
+	for _, block := range strings.Split(string(body), "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event:"); ok {
+				event.name = name
+			} else if data, ok := strings.CutPrefix(line, "data:"); ok {
+				event.data = data
+			}
+		}
+		if event.name != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// streamOutput returns the concatenated output events of stream, which must all come before the final event
+func streamOutput(t *testing.T, events []sseEvent, stream string, offset int64) string {
+	t.Helper()
+
+	var output strings.Builder
+	for _, event := range events[:len(events)-1] {
+		if event.name != stream {
+			continue
+		}
+		var chunk storage.OutputEvent
+		require.NoError(t, json.Unmarshal([]byte(event.data), &chunk))
+		assert.Equal(t, offset, chunk.Offset, "%s chunks are contiguous", stream)
+		offset = chunk.NextOffset
+		output.WriteString(chunk.Data)
+	}
+	return output.String()
+}
+
+func TestStreamCommand(t *testing.T) {
+	server, e := newTestServer(t, Config{})
+
+	// the job is still running when the stream starts
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command: "sh",
+		Args:    []string{"-c", "printf 0123; sleep 0.3; printf 456789; printf abc >&2"},
+	})
+	require.NoError(t, err)
+
+	resp := doRequest(t, server, http.MethodGet, "/api/v1/commands/"+job.ID+"/stream", adminKey, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := readEvents(t, resp)
+	require.NotEmpty(t, events)
+	assert.Equal(t, "0123456789", streamOutput(t, events, "stdout", 0))
+	assert.Equal(t, "abc", streamOutput(t, events, "stderr", 0))
+
+	last := events[len(events)-1]
+	require.Equal(t, "status", last.name, "the stream ends with the job")
+	var finished storage.Job
+	require.NoError(t, json.Unmarshal([]byte(last.data), &finished))
+	assert.Equal(t, job.ID, finished.ID)
+	assert.Equal(t, storage.StatusCompleted, finished.Status)
+
+	// a resumed stream replays the output from the offsets on
+	resp = doRequest(t, server, http.MethodGet, "/api/v1/commands/"+job.ID+"/stream?stdout_offset=4&stderr_offset=3", adminKey, "", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	events = readEvents(t, resp)
+	require.NotEmpty(t, events)
+	assert.Equal(t, "456789", streamOutput(t, events, "stdout", 4))
+	assert.Empty(t, streamOutput(t, events, "stderr", 3))
+	assert.Equal(t, "status", events[len(events)-1].name)
+
+	resp = doRequest(t, server, http.MethodGet, "/api/v1/commands/missing/stream", adminKey, "", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestExecuteWait(t *testing.T) {
+	server, e := newTestServer(t, Config{})
+
+	resp := postJSON(t, server, "/api/v1/commands", adminKey,
+		&storage.ExecuteRequest{Command: "echo", Args: []string{"hello"}, Wait: true})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	job := decode[storage.Job](t, resp)
+	assert.Equal(t, storage.StatusCompleted, job.Status, "the job finished within the wait")
+	assert.Equal(t, "hello\n", job.Stdout)
+
+	start := time.Now()
+	resp = postJSON(t, server, "/api/v1/commands", adminKey,
+		&storage.ExecuteRequest{Command: "sleep", Args: []string{"30"}, Wait: true, MaxWait: 1})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode, "the job is still running when the wait expires")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	accepted := decode[storage.ExecuteResponse](t, resp)
+	require.NotEmpty(t, accepted.JobID)
+	assert.Equal(t, storage.StatusRunning, accepted.Status, "the status is that at the end of the wait")
+	require.NoError(t, e.CancelJob(accepted.JobID))
+}
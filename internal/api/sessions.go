@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/scylladb/sct-agent/internal/executor"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	sessionPingInterval = 30 * time.Second
+	sessionStartTimeout = 30 * time.Second
+	maxSessionMessage   = 1 << 20
+)
+
+var sessionUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	// the agent is not meant to be used from browsers, every client has to authenticate itself explicitly
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// handles GET /api/v1/sessions
+//
+// Upgrades the connection to a WebSocket attached to the command running under a pseudo-terminal, see
+// storage.SessionMessage for the framing. The client describes the session in the "start" message, which has
+// to come within sessionStartTimeout, rather than in the URL, so that env values never end up in access logs.
+// The key policy applies as for POST /api/v1/commands, rejected sessions get an "error" message.
+func (s *Server) openSession(c *gin.Context) {
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		c.JSON(http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Invalid session request",
+			Message: "Sessions require a WebSocket upgrade",
+		})
+		return
+	}
+
+	// sessions outlive the server timeouts, the deadlines are kept by the hijacked connection
+	controller := http.NewResponseController(c.Writer)
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	conn, err := sessionUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already responded with an error
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxSessionMessage)
+
+	session, status, response := s.startSession(c, conn)
+	if session == nil {
+		setAuditError(c, response.Message)
+		recordAudit(c, status)
+		rejectSession(conn, status, response)
+		return
+	}
+
+	// the session is recorded when it starts rather than when it ends
+	setAuditJob(c, session.ID())
+	recordAudit(c, http.StatusSwitchingProtocols)
+
+	serveSession(conn, session)
+}
+
+// startSession reads the "start" message and starts the session, or returns the status and the error response
+// of the rejected request
+func (s *Server) startSession(c *gin.Context, conn *websocket.Conn) (*executor.Session, int, storage.ErrorResponse) {
+	_ = conn.SetReadDeadline(time.Now().Add(sessionStartTimeout))
+	req, opts, err := readSessionRequest(conn)
+	if err != nil {
+		return nil, http.StatusBadRequest, storage.ErrorResponse{
+			Error:   "Invalid session request",
+			Message: err.Error(),
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	s.setAuditRequest(c, req)
+
+	identity := identityFrom(c)
+	if err := identity.Policy.AuthorizeExecute(req); err != nil {
+		return nil, http.StatusForbidden, storage.ErrorResponse{
+			Error:   "Command not allowed",
+			Message: err.Error(),
+		}
+	}
+	req.SubmittedBy = identity.Name
+
+	session, err := s.executor.StartSession(req, opts)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, executor.ErrInvalidRequest):
+			status = http.StatusBadRequest
+		case errors.Is(err, executor.ErrSessionLimit):
+			status = http.StatusTooManyRequests
+		}
+		return nil, status, storage.ErrorResponse{
+			Error:   "Session failed",
+			Message: err.Error(),
+		}
+	}
+	return session, http.StatusSwitchingProtocols, storage.ErrorResponse{}
+}
+
+// readSessionRequest reads the "start" message, the first message of a session
+func readSessionRequest(conn *websocket.Conn) (*storage.ExecuteRequest, executor.SessionOptions, error) {
+	var opts executor.SessionOptions
+
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, opts, fmt.Errorf("failed to read the start message: %w", err)
+	}
+	var message storage.SessionMessage
+	if messageType != websocket.TextMessage || json.Unmarshal(data, &message) != nil {
+		return nil, opts, fmt.Errorf("the first message must be a JSON control message")
+	}
+	if message.Type != storage.SessionMessageStart || message.Request == nil {
+		return nil, opts, fmt.Errorf("the first message must be %q with the request, got %q",
+			storage.SessionMessageStart, message.Type)
+	}
+
+	request := message.Request
+	if request.Command == "" {
+		return nil, opts, fmt.Errorf("command is required")
+	}
+	if request.Timeout < 0 {
+		return nil, opts, fmt.Errorf("timeout must not be negative, got %d", request.Timeout)
+	}
+	if request.IdleTimeout < 0 {
+		return nil, opts, fmt.Errorf("idle_timeout must not be negative, got %d", request.IdleTimeout)
+	}
+
+	req := &storage.ExecuteRequest{
+		Command:    request.Command,
+		Args:       request.Args,
+		WorkingDir: request.WorkingDir,
+		Env:        request.Env,
+		EnvMode:    request.EnvMode,
+		User:       request.User,
+		Group:      request.Group,
+		Groups:     request.Groups,
+		Timeout:    request.Timeout,
+	}
+	opts = executor.SessionOptions{
+		Rows:        request.Rows,
+		Cols:        request.Cols,
+		Term:        request.Term,
+		IdleTimeout: time.Duration(request.IdleTimeout) * time.Second,
+	}
+	return req, opts, nil
+}
+
+// rejectSession sends the "error" message and closes the connection with the close code closest to status
+func rejectSession(conn *websocket.Conn, status int, response storage.ErrorResponse) {
+	if err := conn.WriteJSON(storage.SessionMessage{
+		Type:   storage.SessionMessageError,
+		Status: status,
+		Error:  &response,
+	}); err != nil {
+		return
+	}
+
+	code := websocket.CloseInternalServerErr
+	switch status {
+	case http.StatusBadRequest, http.StatusForbidden:
+		code = websocket.ClosePolicyViolation
+	case http.StatusTooManyRequests:
+		code = websocket.CloseTryAgainLater
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, response.Error),
+		time.Now().Add(time.Second))
+}
+
+// serveSession passes terminal output to the connection until the command exits, then sends the "exit"
+// message and closes the connection. A client closing the connection terminates the command.
+func serveSession(conn *websocket.Conn, session *executor.Session) {
+	if err := conn.WriteJSON(storage.SessionMessage{Type: storage.SessionMessageStarted, JobID: session.ID()}); err != nil {
+		session.Close()
+		return
+	}
+
+	go readSessionInput(conn, session)
+	go pingSession(conn, session.Done())
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := session.Read(buffer)
+		if n > 0 {
+			if err := conn.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
+				session.Close()
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	<-session.Done()
+	exit := storage.SessionMessage{Type: storage.SessionMessageExit, JobID: session.ID()}
+	if job, err := session.Job(); err == nil {
+		// the client has already received the output
+		finished := *job
+		finished.Stdout = ""
+		exit.Job = &finished
+	}
+	if err := conn.WriteJSON(exit); err != nil {
+		return
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+}
+
+// readSessionInput passes binary messages to the terminal input and handles control messages. The session
+// is closed when the connection breaks or is closed by the client.
+func readSessionInput(conn *websocket.Conn, session *executor.Session) {
+	defer session.Close()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			// input sent after the command exited is dropped
+			_, _ = session.Write(data)
+		case websocket.TextMessage:
+			var message storage.SessionMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				slog.Debug("Invalid session message", "job_id", session.ID()[:8], "error", err)
+				continue
+			}
+			if message.Type == storage.SessionMessageResize {
+				if err := session.Resize(message.Rows, message.Cols); err != nil {
+					slog.Debug("Failed to resize session terminal", "job_id", session.ID()[:8], "error", err)
+				}
+			}
+		}
+	}
+}
+
+// pingSession keeps idle connections from being dropped by proxies
+func pingSession(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(sessionPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/auth"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestOpenSessionForbidden(t *testing.T) {
+	server, e := newTestServer(t, Config{},
+		auth.Key{Name: "echo", Key: "echo-key", Policy: auth.Policy{AllowedCommands: []string{"echo"}}},
+		auth.Key{Name: "reader", Key: "reader-key", Policy: auth.Policy{ReadOnly: true}})
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/sessions"
+
+	// open sends the "start" message and returns the answer of the agent
+	open := func(key string, req *storage.SessionRequest) storage.SessionMessage {
+		conn, resp, err := websocket.DefaultDialer.Dial(endpoint, http.Header{"Authorization": {"Bearer " + key}})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteJSON(storage.SessionMessage{Type: storage.SessionMessageStart, Request: req}))
+		var message storage.SessionMessage
+		require.NoError(t, conn.ReadJSON(&message))
+		return message
+	}
+
+	for name, test := range map[string]struct {
+		key    string
+		req    *storage.SessionRequest
+		status int
+	}{
+		"command":   {"echo-key", &storage.SessionRequest{Command: "bash"}, http.StatusForbidden},
+		"read only": {"reader-key", &storage.SessionRequest{Command: "echo"}, http.StatusForbidden},
+		"user":      {"echo-key", &storage.SessionRequest{Command: "echo", User: "root"}, http.StatusForbidden},
+		"missing":   {"echo-key", nil, http.StatusBadRequest},
+	} {
+		message := open(test.key, test.req)
+		assert.Equal(t, storage.SessionMessageError, message.Type, name)
+		assert.Equal(t, test.status, message.Status, name)
+		assert.NotNil(t, message.Error, name)
+	}
+	_, total, err := e.ListJobs("", 10, 0, nil)
+	require.NoError(t, err)
+	assert.Zero(t, total, "no session is started for rejected requests")
+
+	message := open("echo-key", &storage.SessionRequest{Command: "echo", Args: []string{"hello"}})
+	assert.Equal(t, storage.SessionMessageStarted, message.Type, "the key may open sessions running allowed commands")
+	assert.NotEmpty(t, message.JobID)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSConfig configures serving the API over HTTPS
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables verification of client certificates signed by the CAs in the file
+	ClientCAFile string
+	// RequireClientCert rejects connections without a valid client certificate
+	RequireClientCert bool
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration
+}
+
+// CertificateReloader provides the server certificate and the client CAs loaded from files. The files are
+// watched for changes and reloaded, so that certificates can be rotated without restarting the agent.
+type CertificateReloader struct {
+	config TLSConfig
+
+	mutex   sync.RWMutex
+	current *tls.Config
+	stamp   string
+}
+
+func NewCertificateReloader(config TLSConfig) (*CertificateReloader, error) {
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = time.Minute
+	}
+
+	r := &CertificateReloader{config: config}
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(stamp); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the configuration to be used by the HTTP server, which always serves the most
+// recently loaded certificates
+func (r *CertificateReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			return r.current, nil
+		},
+	}
+}
+
+// Watch reloads the certificates whenever the files change, until the context is done. When the new files
+// cannot be loaded, e.g. because only one of them was replaced so far, the previous certificates are kept.
+func (r *CertificateReloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				slog.Error("Failed to reload TLS certificates", "error", err)
+			}
+		}
+	}
+}
+
+// reload loads the files if they changed since they were last loaded
+func (r *CertificateReloader) reload() error {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return err
+	}
+
+	r.mutex.RLock()
+	unchanged := stamp == r.stamp
+	r.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	if err := r.load(stamp); err != nil {
+		return err
+	}
+	slog.Info("TLS certificates reloaded", "cert_file", r.config.CertFile)
+	return nil
+}
+
+func (r *CertificateReloader) load(stamp string) error {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// streams and sessions rely on HTTP/1.1 connections
+		NextProtos: []string{"http/1.1"},
+	}
+
+	if r.config.ClientCAFile != "" {
+		data, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.config.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if r.config.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.current = config
+	r.stamp = stamp
+	return nil
+}
+
+// fileStamp identifies the current version of the files by their sizes and modification times
+func (r *CertificateReloader) fileStamp() (string, error) {
+	var stamp strings.Builder
+	for _, path := range []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&stamp, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
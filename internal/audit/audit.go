@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionExecute     = "execute"
+	ActionPipeline    = "pipeline"
+	ActionCancel      = "cancel"
+	ActionSession     = "session"
+	ActionUpload      = "upload"
+	ActionDownload    = "download"
+	ActionCleanup     = "cleanup"
+	ActionAuthFailure = "auth_failure"
+)
+
+// Log appends events to a file, which is renamed to <file>.1 once it would exceed the maximum size, shifting
+// older files up to <file>.<maxBackups>. The methods do nothing on a nil Log.
+type Log struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// Open opens the audit log at path for appending, creating its directory if needed
+func Open(path string, maxBytes int64, maxBackups int) (*Log, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("maximum audit log size must be greater than 0")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	l := &Log{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record appends the event to the log. Failures are logged, they do not fail the audited action.
+func (l *Log) Record(event *storage.AuditEvent) {
+	if l == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode audit event", "action", event.Action, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.closed {
+		return
+	}
+	if l.file == nil {
+		// a failed rotation is retried with the next event
+		if err := l.open(); err != nil {
+			slog.Error("Failed to write audit event", "action", event.Action, "error", err)
+			return
+		}
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			slog.Error("Failed to rotate audit log", "path", l.path, "error", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write audit event", "action", event.Action, "error", err)
+	}
+}
+
+// rotate closes the current file, shifts the backups and opens a new file, l.mutex must be held by the caller
+func (l *Log) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	if l.maxBackups <= 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else {
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(l.backup(i), l.backup(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.backup(1)); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+func (l *Log) backup(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// Query returns the events between since and until, both optional, oldest first. At most limit events are
+// returned, the second return value reports whether more events matched.
+func (l *Log) Query(since, until *time.Time, limit int) ([]storage.AuditEvent, bool, error) {
+	events := []storage.AuditEvent{}
+	if l == nil {
+		return events, false, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	files := make([]string, 0, l.maxBackups+1)
+	for i := l.maxBackups; i >= 1; i-- {
+		files = append(files, l.backup(i))
+	}
+	files = append(files, l.path)
+
+	for _, path := range files {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var event storage.AuditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			if (since != nil && event.Time.Before(*since)) || (until != nil && event.Time.After(*until)) {
+				continue
+			}
+			if limit > 0 && len(events) == limit {
+				file.Close()
+				return events, true, nil
+			}
+			events = append(events, event)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+	return events, false, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	log, err := Open(path, 300, 2)
+	require.NoError(t, err)
+	defer log.Close()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		log.Record(&storage.AuditEvent{
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			Action:   ActionExecute,
+			Identity: "sct-runner",
+			JobID:    fmt.Sprintf("job-%d", i),
+		})
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(300))
+	}
+	assert.NoFileExists(t, path+".3")
+
+	events, truncated, err := log.Query(nil, nil, 0)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	require.NotEmpty(t, events)
+	assert.Less(t, len(events), 10, "the oldest events are rotated out")
+	assert.Equal(t, "job-9", events[len(events)-1].JobID)
+	for i := 1; i < len(events); i++ {
+		assert.True(t, events[i].Time.After(events[i-1].Time), "events are returned oldest first")
+	}
+}
+
+func TestLogQuery(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.log"), 1<<20, 1)
+	require.NoError(t, err)
+	defer log.Close()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		log.Record(&storage.AuditEvent{Time: start.Add(time.Duration(i) * time.Hour), Action: ActionCancel, JobID: fmt.Sprint(i)})
+	}
+
+	since, until := start.Add(time.Hour), start.Add(3*time.Hour)
+	events, truncated, err := log.Query(&since, &until, 0)
+	require.NoError(t, err)
+	assert.False(t, truncated)
+	require.Len(t, events, 3)
+	assert.Equal(t, "1", events[0].JobID)
+	assert.Equal(t, "3", events[2].JobID)
+
+	events, truncated, err = log.Query(&since, nil, 2)
+	require.NoError(t, err)
+	assert.True(t, truncated)
+	assert.Len(t, events, 2)
+
+	var disabled *Log
+	disabled.Record(&storage.AuditEvent{Action: ActionCancel})
+	events, _, err = disabled.Query(nil, nil, 0)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
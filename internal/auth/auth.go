@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// ErrForbidden is returned when the identity is not allowed to perform the requested action
+var ErrForbidden = errors.New("forbidden")
+
+// Policy restricts what the holder of an API key can do. Empty allow-lists do not restrict anything.
+type Policy struct {
+	// ReadOnly keys can only query jobs and download files
+	ReadOnly bool `yaml:"read_only"`
+	// AllowedCommands holds regular expressions matched against the whole command or its resolved path
+	AllowedCommands []string `yaml:"allowed_commands"`
+	// AllowedWorkingDirs holds directories within which commands can run
+	AllowedWorkingDirs []string `yaml:"allowed_working_dirs"`
+	// AllowedEnv holds glob patterns of environment variables which can be set for commands
+	AllowedEnv []string `yaml:"allowed_env"`
+	// MaxTimeout limits the timeout of commands in seconds, 0 means unlimited
+	MaxTimeout int `yaml:"max_timeout"`
+	// OwnJobsOnly restricts the key to querying and cancelling jobs it submitted
+	OwnJobsOnly bool `yaml:"own_jobs_only"`
+	// AllowedUsers holds glob patterns of the users commands can run as, matched against the name and the UID.
+	// Requests setting a user are rejected without it. Requests without a user run as the agent user, which
+	// has to be allowed as well when AllowedUsers is set.
+	AllowedUsers []string `yaml:"allowed_users"`
+	// AllowedGroups holds glob patterns of the groups and supplementary groups a request can set, requests
+	// setting groups are rejected without it
+	AllowedGroups []string `yaml:"allowed_groups"`
+
+	commands []*regexp.Regexp
+}
+
+// Key is an API key with its name and policy. In the configuration it is either an object
+// or just the key string, which grants full access.
+//
+// CertSubject maps TLS client certificates to the same identity. It is matched against the common name
+// or the whole subject of the certificate, e.g. "CN=sct-runner,O=ScyllaDB". Either Key or CertSubject,
+// or both, must be set.
+type Key struct {
+	Name        string `yaml:"name"`
+	Key         string `yaml:"key"`
+	CertSubject string `yaml:"cert_subject"`
+	Policy      Policy `yaml:"policy"`
+}
+
+func (k *Key) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var key string
+	if err := unmarshal(&key); err == nil {
+		*k = Key{Key: key}
+		return nil
+	}
+
+	type plain Key
+	return unmarshal((*plain)(k))
+}
+
+// Identity is the authenticated holder of an API key
+type Identity struct {
+	Name   string
+	Policy *Policy
+}
+
+// Keyring resolves API keys and client certificate subjects to identities
+type Keyring struct {
+	identities map[string]*Identity
+	subjects   map[string]*Identity
+}
+
+// NewKeyring validates the keys and compiles their policies. Keys without a name are named
+// after their position in the list.
+func NewKeyring(keys []Key) (*Keyring, error) {
+	kr := &Keyring{
+		identities: make(map[string]*Identity, len(keys)),
+		subjects:   make(map[string]*Identity),
+	}
+	names := make(map[string]bool, len(keys))
+
+	for i, key := range keys {
+		if key.Key == "" && key.CertSubject == "" {
+			return nil, fmt.Errorf("API key #%d has neither a key nor a certificate subject", i+1)
+		}
+		if _, exists := kr.identities[key.Key]; exists && key.Key != "" {
+			return nil, fmt.Errorf("API key #%d is configured more than once", i+1)
+		}
+		if _, exists := kr.subjects[key.CertSubject]; exists && key.CertSubject != "" {
+			return nil, fmt.Errorf("certificate subject %q is configured more than once", key.CertSubject)
+		}
+
+		name := key.Name
+		if name == "" {
+			name = fmt.Sprintf("key-%d", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("API key name %q is used more than once", name)
+		}
+		names[name] = true
+
+		policy := key.Policy
+		if err := policy.compile(); err != nil {
+			return nil, fmt.Errorf("invalid policy of API key %q: %w", name, err)
+		}
+
+		identity := &Identity{Name: name, Policy: &policy}
+		if key.Key != "" {
+			kr.identities[key.Key] = identity
+		}
+		if key.CertSubject != "" {
+			kr.subjects[key.CertSubject] = identity
+		}
+	}
+
+	return kr, nil
+}
+
+// Lookup returns the identity holding the key
+func (kr *Keyring) Lookup(key string) (*Identity, bool) {
+	identity, exists := kr.identities[key]
+	return identity, exists
+}
+
+// LookupCertificate returns the identity mapped to the subject of a verified client certificate
+func (kr *Keyring) LookupCertificate(cert *x509.Certificate) (*Identity, bool) {
+	if identity, exists := kr.subjects[cert.Subject.String()]; exists {
+		return identity, true
+	}
+	if cert.Subject.CommonName == "" {
+		return nil, false
+	}
+	identity, exists := kr.subjects[cert.Subject.CommonName]
+	return identity, exists
+}
+
+func (p *Policy) compile() error {
+	p.commands = make([]*regexp.Regexp, 0, len(p.AllowedCommands))
+	for _, pattern := range p.AllowedCommands {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid allowed command %q: %w", pattern, err)
+		}
+		p.commands = append(p.commands, re)
+	}
+
+	for _, dir := range p.AllowedWorkingDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("allowed working directory must be absolute: %s", dir)
+		}
+	}
+
+	for _, pattern := range p.AllowedEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid allowed env pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, patterns := range [][]string{p.AllowedUsers, p.AllowedGroups} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid allowed user or group pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	if p.MaxTimeout < 0 {
+		return fmt.Errorf("max_timeout must not be negative")
+	}
+	return nil
+}
+
+// AuthorizeWrite checks whether the identity can modify state, e.g. cancel jobs or upload files
+func (p *Policy) AuthorizeWrite() error {
+	if p.ReadOnly {
+		return fmt.Errorf("%w: API key is read-only", ErrForbidden)
+	}
+	return nil
+}
+
+// AuthorizeAdmin checks whether the policy allows administrative operations, which affect the jobs of all
+// keys and are therefore reserved to keys without any restriction
+func (p *Policy) AuthorizeAdmin() error {
+	restricted := p.ReadOnly || p.OwnJobsOnly || p.MaxTimeout > 0 ||
+		len(p.AllowedCommands) > 0 || len(p.AllowedWorkingDirs) > 0 || len(p.AllowedEnv) > 0 ||
+		len(p.AllowedUsers) > 0 || len(p.AllowedGroups) > 0
+	if restricted {
+		return fmt.Errorf("%w: administrative operations require an unrestricted API key", ErrForbidden)
+	}
+	return nil
+}
+
+// AuthorizeRead checks whether the policy allows reading the file at path, whose symlinks must be resolved. Files
+// under the readable roots of the agent or the allowed working directories of the key can be read by any key,
+// other files, which include the agent configuration and private keys, only by keys without any restriction.
+func (p *Policy) AuthorizeRead(path string, readableRoots []string) error {
+	if withinDirs(path, resolveDirs(readableRoots)) || withinDirs(path, resolveDirs(p.AllowedWorkingDirs)) {
+		return nil
+	}
+	if p.AuthorizeAdmin() != nil {
+		return fmt.Errorf("%w: reading files outside of the readable roots requires an unrestricted API key", ErrForbidden)
+	}
+	return nil
+}
+
+// AuthorizeUpload checks whether the policy allows writing the file at path, whose symlinks must be resolved. Keys
+// with any restriction can only write under their allowed working directories, otherwise they could replace the
+// programs or scripts run by the commands they are allowed to execute.
+func (p *Policy) AuthorizeUpload(path string) error {
+	if withinDirs(path, resolveDirs(p.AllowedWorkingDirs)) {
+		return nil
+	}
+	if p.AuthorizeAdmin() != nil {
+		return fmt.Errorf("%w: writing files outside of the allowed working directories requires an unrestricted API key", ErrForbidden)
+	}
+	return nil
+}
+
+// AuthorizeOwner checks whether the policy allows giving files to owner and group, which may be empty. As for
+// running commands as another user, they have to be listed in AllowedUsers and AllowedGroups.
+func (p *Policy) AuthorizeOwner(owner, group string) error {
+	if owner != "" && !matchAny(p.AllowedUsers, userNames(owner)) {
+		return fmt.Errorf("%w: owner %q is not allowed", ErrForbidden, owner)
+	}
+	if group != "" && !matchAny(p.AllowedGroups, groupNames(group)) {
+		return fmt.Errorf("%w: group %q is not allowed", ErrForbidden, group)
+	}
+	return nil
+}
+
+// AuthorizeExecute checks whether the request is allowed by the policy. A request without
+// a timeout gets the maximum timeout allowed by the policy.
+func (p *Policy) AuthorizeExecute(req *storage.ExecuteRequest) error {
+	if err := p.AuthorizeWrite(); err != nil {
+		return err
+	}
+
+	if len(p.commands) > 0 && !p.commandAllowed(req.Command) {
+		return fmt.Errorf("%w: command %q is not allowed", ErrForbidden, req.Command)
+	}
+
+	if len(p.AllowedWorkingDirs) > 0 {
+		dir := req.WorkingDir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		} else if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		if !withinDirs(dir, p.AllowedWorkingDirs) {
+			return fmt.Errorf("%w: working directory %q is not allowed", ErrForbidden, dir)
+		}
+	}
+
+	if len(p.AllowedEnv) > 0 {
+		for name := range req.Env {
+			if !p.envAllowed(name) {
+				return fmt.Errorf("%w: environment variable %q is not allowed", ErrForbidden, name)
+			}
+		}
+	}
+
+	// switching users and groups has to be granted explicitly, also to otherwise unrestricted keys
+	if req.User != "" || len(p.AllowedUsers) > 0 {
+		name := req.User
+		if name == "" {
+			if current, err := user.Current(); err == nil {
+				name = current.Username
+			}
+		}
+		if !matchAny(p.AllowedUsers, userNames(name)) {
+			return fmt.Errorf("%w: running as user %q is not allowed", ErrForbidden, name)
+		}
+	}
+
+	for _, group := range append([]string{req.Group}, req.Groups...) {
+		if group != "" && !matchAny(p.AllowedGroups, groupNames(group)) {
+			return fmt.Errorf("%w: running as group %q is not allowed", ErrForbidden, group)
+		}
+	}
+
+	if p.MaxTimeout > 0 {
+		if req.Timeout > p.MaxTimeout {
+			return fmt.Errorf("%w: timeout %d exceeds the maximum of %d seconds", ErrForbidden, req.Timeout, p.MaxTimeout)
+		}
+		if req.Timeout == 0 {
+			req.Timeout = p.MaxTimeout
+		}
+	}
+
+	return nil
+}
+
+// CanAccess checks whether the identity can see and manage the job
+func (id *Identity) CanAccess(job *storage.Job) bool {
+	return !id.Policy.OwnJobsOnly || job.SubmittedBy == id.Name
+}
+
+// CanAccessPipeline checks whether the identity can see and manage the pipeline
+func (id *Identity) CanAccessPipeline(pipeline *storage.Pipeline) bool {
+	return !id.Policy.OwnJobsOnly || pipeline.SubmittedBy == id.Name
+}
+
+func (p *Policy) commandAllowed(command string) bool {
+	candidates := []string{command}
+	if resolved, err := exec.LookPath(command); err == nil {
+		if abs, err := filepath.Abs(resolved); err == nil {
+			resolved = abs
+		}
+		candidates = append(candidates, resolved)
+	}
+
+	for _, re := range p.commands {
+		for _, candidate := range candidates {
+			if re.MatchString(candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Policy) envAllowed(name string) bool {
+	for _, pattern := range p.AllowedEnv {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns, candidates []string) bool {
+	for _, pattern := range patterns {
+		for _, candidate := range candidates {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userNames returns the user name or UID together with its counterpart, so that a policy listing names
+// cannot be bypassed by numeric IDs
+func userNames(name string) []string {
+	names := []string{name}
+	if u, err := user.LookupId(name); err == nil {
+		names = append(names, u.Username)
+	} else if u, err := user.Lookup(name); err == nil {
+		names = append(names, u.Uid)
+	}
+	return names
+}
+
+// groupNames returns the group name or GID together with its counterpart
+func groupNames(name string) []string {
+	names := []string{name}
+	if g, err := user.LookupGroupId(name); err == nil {
+		names = append(names, g.Name)
+	} else if g, err := user.LookupGroup(name); err == nil {
+		names = append(names, g.Gid)
+	}
+	return names
+}
+
+// resolveDirs returns the directories with their symlinks resolved, directories which do not exist are kept as is
+func resolveDirs(dirs []string) []string {
+	resolved := make([]string, len(dirs))
+	for i, dir := range dirs {
+		resolved[i] = dir
+		if r, err := filepath.EvalSymlinks(dir); err == nil {
+			resolved[i] = r
+		}
+	}
+	return resolved
+}
+
+func withinDirs(dir string, allowed []string) bool {
+	dir = filepath.Clean(dir)
+	for _, a := range allowed {
+		a = filepath.Clean(a)
+		if dir == a || strings.HasPrefix(dir, strings.TrimSuffix(a, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
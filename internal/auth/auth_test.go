@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestKeyUnmarshal(t *testing.T) {
+	var keys []Key
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- "plain-key"
+- name: "reader"
+  key: "reader-key"
+  policy:
+    read_only: true
+`), &keys))
+
+	require.Len(t, keys, 2)
+	assert.Equal(t, Key{Key: "plain-key"}, keys[0])
+	assert.Equal(t, "reader", keys[1].Name)
+	assert.Equal(t, "reader-key", keys[1].Key)
+	assert.True(t, keys[1].Policy.ReadOnly)
+}
+
+func TestNewKeyring(t *testing.T) {
+	kr, err := NewKeyring([]Key{{Key: "a"}, {Name: "named", Key: "b"}})
+	require.NoError(t, err)
+
+	identity, ok := kr.Lookup("a")
+	require.True(t, ok)
+	assert.Equal(t, "key-1", identity.Name)
+
+	identity, ok = kr.Lookup("b")
+	require.True(t, ok)
+	assert.Equal(t, "named", identity.Name)
+
+	_, ok = kr.Lookup("c")
+	assert.False(t, ok)
+
+	_, err = NewKeyring([]Key{{Key: "a"}, {Key: "a"}})
+	assert.Error(t, err)
+
+	_, err = NewKeyring([]Key{{Name: "x", Key: "a"}, {Name: "x", Key: "b"}})
+	assert.Error(t, err)
+
+	_, err = NewKeyring([]Key{{Key: "a", Policy: Policy{AllowedCommands: []string{"("}}}})
+	assert.Error(t, err)
+
+	_, err = NewKeyring([]Key{{Key: "a", Policy: Policy{AllowedWorkingDirs: []string{"relative"}}}})
+	assert.Error(t, err)
+}
+
+func TestAuthorizeExecute(t *testing.T) {
+	policy := Policy{
+		AllowedCommands:    []string{"echo", "/usr/bin/nodetool"},
+		AllowedWorkingDirs: []string{"/tmp"},
+		AllowedEnv:         []string{"JAVA_*"},
+		MaxTimeout:         60,
+	}
+	require.NoError(t, policy.compile())
+
+	req := &storage.ExecuteRequest{Command: "echo", WorkingDir: "/tmp/work", Env: map[string]string{"JAVA_OPTS": "-Xmx1g"}}
+	require.NoError(t, policy.AuthorizeExecute(req))
+	assert.Equal(t, 60, req.Timeout, "missing timeout is capped")
+
+	for name, req := range map[string]*storage.ExecuteRequest{
+		"command":        {Command: "echoes", WorkingDir: "/tmp"},
+		"working dir":    {Command: "echo", WorkingDir: "/etc"},
+		"dir escape":     {Command: "echo", WorkingDir: "/tmp/../etc"},
+		"dir prefix":     {Command: "echo", WorkingDir: "/tmpfoo"},
+		"env":            {Command: "echo", WorkingDir: "/tmp", Env: map[string]string{"PATH": "/"}},
+		"timeout":        {Command: "echo", WorkingDir: "/tmp", Timeout: 61},
+		"unknown binary": {Command: "/usr/bin/nodetool-evil", WorkingDir: "/tmp"},
+	} {
+		assert.ErrorIs(t, policy.AuthorizeExecute(req), ErrForbidden, name)
+	}
+
+	readOnly := Policy{ReadOnly: true}
+	require.NoError(t, readOnly.compile())
+	assert.ErrorIs(t, readOnly.AuthorizeExecute(&storage.ExecuteRequest{Command: "echo"}), ErrForbidden)
+	assert.ErrorIs(t, readOnly.AuthorizeWrite(), ErrForbidden)
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	policy := Policy{AllowedUsers: []string{"daemon", "nobody"}, AllowedGroups: []string{"daemon"}}
+	require.NoError(t, policy.compile())
+
+	assert.NoError(t, policy.AuthorizeExecute(&storage.ExecuteRequest{Command: "id", User: "daemon", Group: "daemon"}))
+	assert.NoError(t, policy.AuthorizeExecute(&storage.ExecuteRequest{Command: "id", User: "nobody"}))
+
+	for name, req := range map[string]*storage.ExecuteRequest{
+		"agent user":    {Command: "id"},
+		"user":          {Command: "id", User: "root"},
+		"numeric user":  {Command: "id", User: "0"},
+		"group":         {Command: "id", User: "daemon", Group: "root"},
+		"supplementary": {Command: "id", User: "daemon", Groups: []string{"daemon", "0"}},
+	} {
+		assert.ErrorIs(t, policy.AuthorizeExecute(req), ErrForbidden, name)
+	}
+
+	unrestricted := Policy{}
+	require.NoError(t, unrestricted.compile())
+	assert.NoError(t, unrestricted.AuthorizeExecute(&storage.ExecuteRequest{Command: "id"}))
+	for name, req := range map[string]*storage.ExecuteRequest{
+		"user":          {Command: "id", User: "root"},
+		"group":         {Command: "id", Group: "daemon"},
+		"supplementary": {Command: "id", Groups: []string{"0"}},
+	} {
+		assert.ErrorIs(t, unrestricted.AuthorizeExecute(req), ErrForbidden, "%s without an allow-list", name)
+	}
+}
+
+func TestAuthorizeOwner(t *testing.T) {
+	policy := Policy{AllowedUsers: []string{"daemon"}, AllowedGroups: []string{"daemon"}}
+
+	assert.NoError(t, policy.AuthorizeOwner("", ""))
+	assert.NoError(t, policy.AuthorizeOwner("daemon", "daemon"))
+	assert.ErrorIs(t, policy.AuthorizeOwner("root", ""), ErrForbidden)
+	assert.ErrorIs(t, policy.AuthorizeOwner("0", ""), ErrForbidden)
+	assert.ErrorIs(t, policy.AuthorizeOwner("daemon", "0"), ErrForbidden)
+	assert.ErrorIs(t, (&Policy{}).AuthorizeOwner("root", ""), ErrForbidden, "owners have to be listed")
+	assert.ErrorIs(t, (&Policy{}).AuthorizeOwner("", "root"), ErrForbidden)
+}
+
+func TestAuthorizeAdmin(t *testing.T) {
+	assert.NoError(t, (&Policy{}).AuthorizeAdmin())
+	assert.ErrorIs(t, (&Policy{ReadOnly: true}).AuthorizeAdmin(), ErrForbidden)
+	assert.ErrorIs(t, (&Policy{OwnJobsOnly: true}).AuthorizeAdmin(), ErrForbidden)
+	assert.ErrorIs(t, (&Policy{AllowedCommands: []string{"echo"}}).AuthorizeAdmin(), ErrForbidden)
+}
+
+func TestAuthorizeRead(t *testing.T) {
+	roots := []string{"/var/log/scylla"}
+
+	assert.NoError(t, (&Policy{}).AuthorizeRead("/etc/sct-agent/agent.yaml", roots), "unrestricted keys read any file")
+	assert.NoError(t, (&Policy{ReadOnly: true}).AuthorizeRead("/var/log/scylla/system.log", roots))
+	assert.NoError(t, (&Policy{AllowedWorkingDirs: []string{"/tmp"}}).AuthorizeRead("/tmp/out/result.txt", roots))
+
+	assert.ErrorIs(t, (&Policy{ReadOnly: true}).AuthorizeRead("/etc/sct-agent/agent.yaml", roots), ErrForbidden)
+	assert.ErrorIs(t, (&Policy{AllowedCommands: []string{"echo"}}).AuthorizeRead("/etc/shadow", nil), ErrForbidden)
+	assert.ErrorIs(t, (&Policy{ReadOnly: true}).AuthorizeRead("/var/log/scylla-other/x", roots), ErrForbidden)
+}
+
+func TestAuthorizeUpload(t *testing.T) {
+	assert.NoError(t, (&Policy{}).AuthorizeUpload("/etc/scylla/scylla.yaml"), "unrestricted keys write any file")
+	assert.NoError(t, (&Policy{AllowedWorkingDirs: []string{"/tmp"}}).AuthorizeUpload("/tmp/out/data.txt"))
+
+	assert.ErrorIs(t, (&Policy{AllowedWorkingDirs: []string{"/tmp"}}).AuthorizeUpload("/etc/scylla/scylla.yaml"), ErrForbidden)
+	assert.ErrorIs(t, (&Policy{AllowedCommands: []string{"echo"}}).AuthorizeUpload("/tmp/script.sh"), ErrForbidden)
+}
+
+func TestCanAccess(t *testing.T) {
+	job := &storage.Job{ID: "job", SubmittedBy: "alice"}
+	pipeline := &storage.Pipeline{ID: "pipeline", SubmittedBy: "alice"}
+
+	assert.True(t, (&Identity{Name: "bob", Policy: &Policy{}}).CanAccess(job))
+	assert.True(t, (&Identity{Name: "alice", Policy: &Policy{OwnJobsOnly: true}}).CanAccess(job))
+	assert.False(t, (&Identity{Name: "bob", Policy: &Policy{OwnJobsOnly: true}}).CanAccess(job))
+
+	assert.True(t, (&Identity{Name: "bob", Policy: &Policy{}}).CanAccessPipeline(pipeline))
+	assert.True(t, (&Identity{Name: "alice", Policy: &Policy{OwnJobsOnly: true}}).CanAccessPipeline(pipeline))
+	assert.False(t, (&Identity{Name: "bob", Policy: &Policy{OwnJobsOnly: true}}).CanAccessPipeline(pipeline))
+}
+
+func TestLookupCertificate(t *testing.T) {
+	kr, err := NewKeyring([]Key{
+		{Name: "runner", CertSubject: "sct-runner"},
+		{Name: "monitor", Key: "monitor-key", CertSubject: "CN=monitor,O=ScyllaDB"},
+	})
+	require.NoError(t, err)
+
+	identity, ok := kr.LookupCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "sct-runner", Organization: []string{"ScyllaDB"}}})
+	require.True(t, ok)
+	assert.Equal(t, "runner", identity.Name)
+
+	identity, ok = kr.LookupCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "monitor", Organization: []string{"ScyllaDB"}}})
+	require.True(t, ok)
+	assert.Equal(t, "monitor", identity.Name)
+
+	_, ok = kr.LookupCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "monitor"}})
+	assert.False(t, ok)
+
+	_, ok = kr.Lookup("")
+	assert.False(t, ok, "certificate-only identities have no API key")
+
+	_, err = NewKeyring([]Key{{Name: "empty"}})
+	assert.Error(t, err)
+}
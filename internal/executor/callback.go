@@ -0,0 +1,159 @@
+package executor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	signatureHeader = "X-SCT-Signature"
+	maxBackoff      = time.Minute
+)
+
+// CallbackConfig configures the delivery of finished jobs to their callbacks
+type CallbackConfig struct {
+	// Default is the callback of jobs submitted without one, may be nil
+	Default *storage.Callback
+	// MaxAttempts is the number of delivery attempts before giving up
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled for each subsequent retry
+	InitialBackoff time.Duration
+	// Timeout limits a single delivery attempt
+	Timeout time.Duration
+}
+
+// ValidateCallback checks that the callback URL is an absolute http or https URL
+func ValidateCallback(callback *storage.Callback) error {
+	u, err := url.Parse(callback.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: callback url must be an absolute http or https URL, got %q", ErrInvalidRequest, callback.URL)
+	}
+	return nil
+}
+
+// deliverCallback POSTs the finished job to its callback, retrying failed attempts with exponential
+// backoff. Retries stop early when the agent shuts down. The delivery status is saved on the job.
+func (e *Executor) deliverCallback(job *storage.Job, callback *storage.Callback) {
+	defer e.deliveries.Done()
+
+	backoff := e.config.Callbacks.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		e.mutex.Lock()
+		payload, err := json.Marshal(job)
+		e.mutex.Unlock()
+		if err != nil {
+			e.recordDelivery(job, attempt, fmt.Errorf("failed to marshal job: %w", err), true)
+			return
+		}
+
+		err = e.postCallback(callback, job.ID, payload)
+		retryable := err != nil && attempt < e.config.Callbacks.MaxAttempts && isRetryable(err)
+		e.recordDelivery(job, attempt, err, !retryable)
+		if !retryable {
+			return
+		}
+
+		slog.Warn("Callback delivery failed, retrying",
+			"job_id", job.ID[:8],
+			"attempt", attempt,
+			"backoff", backoff,
+			"error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-e.closing:
+			e.recordDelivery(job, attempt, fmt.Errorf("agent shut down before delivery: %w", err), true)
+			return
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// callbackStatusError is returned when the callback responds with a non-2xx status
+type callbackStatusError struct {
+	code int
+}
+
+func (e *callbackStatusError) Error() string {
+	return fmt.Sprintf("callback responded with HTTP %d", e.code)
+}
+
+// isRetryable tells whether a failed delivery may succeed when repeated. Client errors other than
+// timeouts and rate limiting are permanent.
+func isRetryable(err error) bool {
+	statusErr, ok := err.(*callbackStatusError)
+	if !ok {
+		return true
+	}
+	return statusErr.code >= 500 || statusErr.code == http.StatusRequestTimeout || statusErr.code == http.StatusTooManyRequests
+}
+
+func (e *Executor) postCallback(callback *storage.Callback, jobID string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, callback.URL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range callback.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-SCT-Job-ID", jobID)
+	if callback.Secret != "" {
+		req.Header.Set(signatureHeader, "sha256="+sign(payload, callback.Secret))
+	}
+
+	resp, err := e.callbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &callbackStatusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the payload
+func sign(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *Executor) recordDelivery(job *storage.Job, attempt int, err error, final bool) {
+	e.mutex.Lock()
+	delivery := *job.Callback
+	delivery.Attempts = attempt
+	switch {
+	case err == nil:
+		now := time.Now()
+		delivery.Status = storage.CallbackDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case final:
+		delivery.Status = storage.CallbackFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+	}
+	job.Callback = &delivery
+	e.mutex.Unlock()
+
+	if err := e.storage.Save(job); err != nil {
+		slog.Error("Failed to save callback delivery status", "job_id", job.ID[:8], "error", err)
+	}
+	if final && err != nil {
+		slog.Error("Callback delivery failed", "job_id", job.ID[:8], "url", delivery.URL, "attempts", attempt, "error", err)
+	}
+}
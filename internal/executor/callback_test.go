@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func newCallbackTestExecutor(t *testing.T, defaultCallback *storage.Callback) *Executor {
+	t.Helper()
+
+	return NewExecutor(Config{
+		MaxConcurrentJobs: 1,
+		OutputDir:         t.TempDir(),
+		Callbacks: CallbackConfig{
+			Default:        defaultCallback,
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+		},
+	}, storage.NewMemory())
+}
+
+func waitForDelivery(t *testing.T, e *Executor, id string) *storage.CallbackDelivery {
+	t.Helper()
+
+	var delivery *storage.CallbackDelivery
+	require.Eventually(t, func() bool {
+		job, _ := e.storage.Get(id)
+		// the delivery status is updated under the executor mutex
+		e.mutex.RLock()
+		delivery = job.Callback
+		e.mutex.RUnlock()
+		return delivery != nil && delivery.Status != storage.CallbackPending
+	}, 5*time.Second, 10*time.Millisecond)
+	return delivery
+}
+
+func TestCallbackDelivery(t *testing.T) {
+	var received atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+sign(body, "secret"), r.Header.Get(signatureHeader))
+		assert.Equal(t, "token", r.Header.Get("X-Token"))
+		received.Store(body)
+	}))
+	defer server.Close()
+
+	e := newCallbackTestExecutor(t, nil)
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command:  "echo",
+		Args:     []string{"hello"},
+		Callback: &storage.Callback{URL: server.URL, Headers: map[string]string{"X-Token": "token"}, Secret: "secret"},
+	})
+	require.NoError(t, err)
+
+	delivery := waitForDelivery(t, e, job.ID)
+	assert.Equal(t, storage.CallbackDelivered, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.NotNil(t, delivery.DeliveredAt)
+
+	var payload storage.Job
+	require.NoError(t, json.Unmarshal(received.Load().([]byte), &payload))
+	assert.Equal(t, job.ID, payload.ID)
+	assert.Equal(t, storage.StatusCompleted, payload.Status)
+	assert.Equal(t, "hello\n", payload.Stdout)
+}
+
+func TestCallbackRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	e := newCallbackTestExecutor(t, &storage.Callback{URL: server.URL})
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "true"})
+	require.NoError(t, err)
+
+	delivery := waitForDelivery(t, e, job.ID)
+	assert.Equal(t, storage.CallbackDelivered, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+}
+
+func TestCallbackPermanentFailure(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	e := newCallbackTestExecutor(t, nil)
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "true", Callback: &storage.Callback{URL: server.URL}})
+	require.NoError(t, err)
+
+	delivery := waitForDelivery(t, e, job.ID)
+	assert.Equal(t, storage.CallbackFailed, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts, "client errors are not retried")
+	assert.Contains(t, delivery.LastError, "HTTP 400")
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+func TestCallbackValidation(t *testing.T) {
+	e := newCallbackTestExecutor(t, nil)
+
+	for _, u := range []string{"", "ftp://example.com", "/relative", "http://"} {
+		_, err := e.Execute(&storage.ExecuteRequest{Command: "true", Callback: &storage.Callback{URL: u}})
+		assert.ErrorIs(t, err, ErrInvalidRequest, u)
+	}
+}
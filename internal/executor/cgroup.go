@@ -0,0 +1,209 @@
+package executor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// cpuPeriod is the cpu.max period in microseconds the CPU quota of jobs is expressed in
+const cpuPeriod = 100000
+
+// Cgroups places every job in its own cgroup v2 child of a parent cgroup, which applies the resource limits
+// of the job and accounts for the resources it uses
+type Cgroups struct {
+	parent string
+	// controllers are the controllers enabled for the children of the parent
+	controllers map[string]bool
+}
+
+// NewCgroups creates the parent cgroup if needed and enables the cpu, memory, pids and io controllers for
+// its children, as far as they are available. The agent itself must not run in the parent cgroup.
+func NewCgroups(parent string) (*Cgroups, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup %s: %w", parent, err)
+	}
+
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2 directory: %w", parent, err)
+	}
+
+	c := &Cgroups{parent: parent, controllers: make(map[string]bool)}
+	for _, controller := range strings.Fields(string(available)) {
+		switch controller {
+		case "cpu", "memory", "pids", "io":
+		default:
+			continue
+		}
+		if err := writeCgroupFile(parent, "cgroup.subtree_control", "+"+controller); err != nil {
+			return nil, fmt.Errorf("failed to enable the %s controller: %w", controller, err)
+		}
+		c.controllers[controller] = true
+	}
+	return c, nil
+}
+
+// validate checks that the limits are in range and their controllers are enabled
+func (c *Cgroups) validate(limits *storage.ResourceLimits) error {
+	if limits == nil {
+		return nil
+	}
+	if c == nil {
+		return fmt.Errorf("%w: resource limits require cgroups, which are not configured on this agent", ErrInvalidRequest)
+	}
+
+	checks := []struct {
+		name       string
+		set        bool
+		valid      bool
+		controller string
+	}{
+		{"cpu_quota", limits.CPUQuota != 0, limits.CPUQuota > 0 && limits.CPUQuota*cpuPeriod >= 1000, "cpu"},
+		{"cpu_weight", limits.CPUWeight != 0, limits.CPUWeight >= 1 && limits.CPUWeight <= 10000, "cpu"},
+		{"memory_max", limits.MemoryMax != 0, limits.MemoryMax > 0, "memory"},
+		{"pids_max", limits.PidsMax != 0, limits.PidsMax > 0, "pids"},
+		{"io_weight", limits.IOWeight != 0, limits.IOWeight >= 1 && limits.IOWeight <= 10000, "io"},
+	}
+	for _, check := range checks {
+		if !check.set {
+			continue
+		}
+		if !check.valid {
+			return fmt.Errorf("%w: %s is out of range", ErrInvalidRequest, check.name)
+		}
+		if !c.controllers[check.controller] {
+			return fmt.Errorf("%w: %s requires the %s cgroup controller, which is not available", ErrInvalidRequest, check.name, check.controller)
+		}
+	}
+	return nil
+}
+
+// create creates the cgroup of the job with its limits applied. It returns nil without cgroups.
+func (c *Cgroups) create(job *storage.Job) (*jobCgroup, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	dir := filepath.Join(c.parent, "job-"+job.ID)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	cgroup := &jobCgroup{dir: dir}
+
+	var settings [][2]string
+	if c.controllers["memory"] {
+		// the whole job is killed instead of a single process of it
+		settings = append(settings, [2]string{"memory.oom.group", "1"})
+	}
+	if limits := job.Limits; limits != nil {
+		if limits.CPUQuota > 0 {
+			settings = append(settings, [2]string{"cpu.max", fmt.Sprintf("%d %d", int64(limits.CPUQuota*cpuPeriod), cpuPeriod)})
+		}
+		if limits.CPUWeight > 0 {
+			settings = append(settings, [2]string{"cpu.weight", strconv.Itoa(limits.CPUWeight)})
+		}
+		if limits.MemoryMax > 0 {
+			settings = append(settings, [2]string{"memory.max", strconv.FormatInt(limits.MemoryMax, 10)})
+		}
+		if limits.PidsMax > 0 {
+			settings = append(settings, [2]string{"pids.max", strconv.Itoa(limits.PidsMax)})
+		}
+		if limits.IOWeight > 0 {
+			settings = append(settings, [2]string{"io.weight", "default " + strconv.Itoa(limits.IOWeight)})
+		}
+	}
+
+	for _, setting := range settings {
+		if err := writeCgroupFile(dir, setting[0], setting[1]); err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", setting[0], err)
+		}
+	}
+	return cgroup, nil
+}
+
+// jobCgroup is the cgroup of a single job, its methods do nothing on a nil jobCgroup
+type jobCgroup struct {
+	dir string
+}
+
+// usage returns the resources used by the processes of the cgroup so far
+func (c *jobCgroup) usage() *storage.JobResources {
+	if c == nil {
+		return nil
+	}
+
+	resources := &storage.JobResources{}
+	// memory.peak is only available since Linux 5.19
+	if peak, err := os.ReadFile(filepath.Join(c.dir, "memory.peak")); err == nil {
+		resources.MemoryPeakBytes, _ = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	if usec, ok := readCgroupStat(c.dir, "cpu.stat", "usage_usec"); ok {
+		resources.CPUTimeMs = usec / 1000
+	}
+	if kills, ok := readCgroupStat(c.dir, "memory.events", "oom_kill"); ok {
+		resources.OOMKills = int(kills)
+	}
+	return resources
+}
+
+// remove kills the processes left in the cgroup, e.g. those which left the process group of the job,
+// and removes the cgroup
+func (c *jobCgroup) remove() {
+	if c == nil {
+		return
+	}
+
+	// cgroup.kill is only available since Linux 5.14, without it the cgroup stays until its processes exit
+	_ = writeCgroupFile(c.dir, "cgroup.kill", "1")
+
+	// the cgroup is busy until the killed processes are reaped
+	var err error
+	for attempt := 0; attempt < 50; attempt++ {
+		if err = os.Remove(c.dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	slog.Warn("Failed to remove job cgroup", "cgroup", c.dir, "error", err)
+}
+
+// markOOMKilled reports a failed job whose cgroup saw OOM kills as killed by the OOM killer
+func markOOMKilled(job *storage.Job) {
+	if job.Status == storage.StatusFailed && job.Resources != nil && job.Resources.OOMKills > 0 {
+		job.Error = "command killed by the OOM killer"
+		job.FailureReason = storage.FailureOOMKilled
+	}
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// readCgroupStat reads the value of key from a flat keyed cgroup file such as cpu.stat
+func readCgroupStat(dir, name, key string) (int64, bool) {
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			value, err := strconv.ParseInt(fields[1], 10, 64)
+			return value, err == nil
+		}
+	}
+	return 0, false
+}
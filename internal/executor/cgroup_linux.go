@@ -0,0 +1,21 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+)
+
+// attach makes the command start in the cgroup, release must be called once the command started
+func (c *jobCgroup) attach(cmd *exec.Cmd) (release func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+
+	dir, err := os.Open(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return func() { dir.Close() }, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package executor
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errCgroupsUnsupported = errors.New("cgroups are only supported on Linux")
+
+func (c *jobCgroup) attach(cmd *exec.Cmd) (release func(), err error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	return nil, errCgroupsUnsupported
+}
@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// newFakeCgroups returns cgroups in a plain directory with the given controllers available
+func newFakeCgroups(t *testing.T, controllers string) *Cgroups {
+	t.Helper()
+
+	parent := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte(controllers+"\n"), 0644))
+	cgroups, err := NewCgroups(parent)
+	require.NoError(t, err)
+	return cgroups
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCgroupLimits(t *testing.T) {
+	cgroups := newFakeCgroups(t, "cpuset cpu io memory hugetlb pids")
+	assert.Equal(t, map[string]bool{"cpu": true, "io": true, "memory": true, "pids": true}, cgroups.controllers)
+
+	job := &storage.Job{ID: "test", Limits: &storage.ResourceLimits{
+		CPUQuota:  1.5,
+		CPUWeight: 50,
+		MemoryMax: 64 << 20,
+		PidsMax:   100,
+		IOWeight:  200,
+	}}
+	require.NoError(t, cgroups.validate(job.Limits))
+
+	cgroup, err := cgroups.create(job)
+	require.NoError(t, err)
+	assert.Equal(t, "150000 100000", readFile(t, filepath.Join(cgroup.dir, "cpu.max")))
+	assert.Equal(t, "50", readFile(t, filepath.Join(cgroup.dir, "cpu.weight")))
+	assert.Equal(t, "67108864", readFile(t, filepath.Join(cgroup.dir, "memory.max")))
+	assert.Equal(t, "1", readFile(t, filepath.Join(cgroup.dir, "memory.oom.group")))
+	assert.Equal(t, "100", readFile(t, filepath.Join(cgroup.dir, "pids.max")))
+	assert.Equal(t, "default 200", readFile(t, filepath.Join(cgroup.dir, "io.weight")))
+
+	require.NoError(t, os.WriteFile(filepath.Join(cgroup.dir, "cpu.stat"), []byte("usage_usec 2500000\nuser_usec 2000000\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(cgroup.dir, "memory.peak"), []byte("1048576\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(cgroup.dir, "memory.events"), []byte("low 0\nmax 3\noom 1\noom_kill 1\n"), 0644))
+	assert.Equal(t, &storage.JobResources{MemoryPeakBytes: 1048576, CPUTimeMs: 2500, OOMKills: 1}, cgroup.usage())
+
+	job.Status = storage.StatusFailed
+	job.Resources = cgroup.usage()
+	markOOMKilled(job)
+	assert.Equal(t, storage.FailureOOMKilled, job.FailureReason)
+}
+
+func TestCgroupValidation(t *testing.T) {
+	cgroups := newFakeCgroups(t, "cpu pids")
+
+	assert.NoError(t, cgroups.validate(nil))
+	assert.NoError(t, cgroups.validate(&storage.ResourceLimits{CPUQuota: 0.5, PidsMax: 10}))
+
+	for name, limits := range map[string]*storage.ResourceLimits{
+		"negative quota":         {CPUQuota: -1},
+		"tiny quota":             {CPUQuota: 0.001},
+		"weight out of range":    {CPUWeight: 20000},
+		"unavailable controller": {MemoryMax: 1 << 20},
+	} {
+		assert.ErrorIs(t, cgroups.validate(limits), ErrInvalidRequest, name)
+	}
+
+	var disabled *Cgroups
+	assert.ErrorIs(t, disabled.validate(&storage.ResourceLimits{PidsMax: 10}), ErrInvalidRequest)
+
+	_, err := newTestExecutor(t, 1).Execute(&storage.ExecuteRequest{Command: "true", Limits: &storage.ResourceLimits{PidsMax: 10}})
+	assert.ErrorIs(t, err, ErrInvalidRequest, "limits are rejected without cgroups")
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// jobCredential returns the credential the job runs with, nil if the job runs as the agent user. User, group and
+// supplementary groups are names or numeric IDs. A job with a user and without a group runs with the primary
+// group of the user, without supplementary groups it gets the groups the user is a member of.
+func jobCredential(job *storage.Job) (*syscall.Credential, error) {
+	if job.User == "" && job.Group == "" && len(job.Groups) == 0 {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{
+		Uid:         uint32(os.Getuid()),
+		Gid:         uint32(os.Getgid()),
+		NoSetGroups: true,
+	}
+
+	if job.User != "" {
+		u, known, err := lookupUser(job.User)
+		if err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		credential.Uid = uint32(uid)
+		credential.Gid = uint32(gid)
+
+		if len(job.Groups) == 0 && known {
+			groupIDs, err := u.GroupIds()
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up groups of user %q: %w", job.User, err)
+			}
+			for _, id := range groupIDs {
+				gid, err := strconv.ParseUint(id, 10, 32)
+				if err == nil {
+					credential.Groups = append(credential.Groups, uint32(gid))
+				}
+			}
+		}
+		// the supplementary groups of the agent are not inherited by other users
+		credential.NoSetGroups = false
+	}
+
+	if job.Group != "" {
+		gid, err := lookupGroupID(job.Group)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid = gid
+	}
+
+	if len(job.Groups) > 0 {
+		credential.Groups = make([]uint32, 0, len(job.Groups))
+		for _, group := range job.Groups {
+			gid, err := lookupGroupID(group)
+			if err != nil {
+				return nil, err
+			}
+			credential.Groups = append(credential.Groups, gid)
+		}
+		credential.NoSetGroups = false
+	}
+
+	return credential, nil
+}
+
+// lookupUser returns the user with the given name or UID and whether it has a passwd entry
+func lookupUser(name string) (*user.User, bool, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, true, nil
+		}
+		// users without a passwd entry can still be switched to, with their UID as the primary group
+		return &user.User{Uid: name, Gid: name, Username: name}, false, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: unknown user %q", ErrInvalidRequest, name)
+	}
+	return u, true, nil
+}
+
+func lookupGroupID(name string) (uint32, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: unknown group %q", ErrInvalidRequest, name)
+	}
+	gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(gid), nil
+}
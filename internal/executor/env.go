@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Env modes select what the environment of a job is built from
+const (
+	// EnvModeInherit runs the job with the agent environment and the base environment, the request cannot set env
+	EnvModeInherit = "inherit"
+	// EnvModeMerge runs the job with the agent environment, the base environment and the env of the request
+	EnvModeMerge = "merge"
+	// EnvModeClean runs the job with the base environment and the env of the request only
+	EnvModeClean = "clean"
+)
+
+// maskedValue replaces the values of sensitive variables in logs and API responses
+const maskedValue = "********"
+
+// EnvConfig configures the environment of jobs
+type EnvConfig struct {
+	// DefaultMode is the env mode of requests without one, EnvModeMerge if empty
+	DefaultMode string
+	// Base is set for every job, on top of the agent environment unless the job runs in EnvModeClean
+	Base map[string]string
+	// Deny holds case-insensitive glob patterns of agent environment variables which are never passed to jobs
+	Deny []string
+	// Sensitive holds case-insensitive glob patterns of variables whose values are masked in logs and API responses
+	Sensitive []string
+}
+
+// ValidateEnvConfig checks the env mode and the patterns of the configuration
+func ValidateEnvConfig(config EnvConfig) error {
+	if config.DefaultMode != "" {
+		if err := validateEnvMode(config.DefaultMode); err != nil {
+			return err
+		}
+	}
+	for _, patterns := range [][]string{config.Deny, config.Sensitive} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid env pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateEnvMode(mode string) error {
+	switch mode {
+	case EnvModeInherit, EnvModeMerge, EnvModeClean:
+		return nil
+	}
+	return fmt.Errorf("unknown env mode %q, expected one of: %s, %s, %s", mode, EnvModeInherit, EnvModeMerge, EnvModeClean)
+}
+
+// envMode returns the env mode of a request, falling back to the default
+func (e *Executor) envMode(mode string, env map[string]string) (string, error) {
+	if mode == "" {
+		mode = e.config.Env.DefaultMode
+	}
+	if err := validateEnvMode(mode); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if mode == EnvModeInherit && len(env) > 0 {
+		return "", fmt.Errorf("%w: env cannot be set with env mode %s", ErrInvalidRequest, EnvModeInherit)
+	}
+	return mode, nil
+}
+
+// environment returns the environment of a command, variables of the request override the base environment,
+// which overrides the agent environment
+func (e *Executor) environment(env map[string]string, mode string) []string {
+	variables := make(map[string]string)
+	if mode != EnvModeClean {
+		for _, variable := range os.Environ() {
+			name, value, _ := strings.Cut(variable, "=")
+			if !matchEnvPattern(e.config.Env.Deny, name) {
+				variables[name] = value
+			}
+		}
+	}
+	for name, value := range e.config.Env.Base {
+		variables[name] = value
+	}
+	for name, value := range env {
+		variables[name] = value
+	}
+
+	// never nil, which would make exec.Cmd inherit the agent environment
+	result := make([]string, 0, len(variables))
+	for name, value := range variables {
+		result = append(result, name+"="+value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// MaskEnv returns a copy of env with the values of sensitive variables masked
+func (e *Executor) MaskEnv(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(env))
+	for name, value := range env {
+		if matchEnvPattern(e.config.Env.Sensitive, name) {
+			value = maskedValue
+		}
+		masked[name] = value
+	}
+	return masked
+}
+
+// matchEnvPattern reports whether the variable name matches any of the patterns, ignoring case
+func matchEnvPattern(patterns []string, name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToUpper(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestEnvModes(t *testing.T) {
+	t.Setenv("SCT_TEST_INHERITED", "agent")
+	t.Setenv("SCT_TEST_DENIED", "secret")
+
+	e := NewExecutor(Config{
+		MaxConcurrentJobs: 1,
+		OutputDir:         t.TempDir(),
+		Env: EnvConfig{
+			Base: map[string]string{"SCT_TEST_BASE": "base"},
+			Deny: []string{"sct_test_denied"},
+		},
+	}, storage.NewMemory())
+
+	run := func(mode string, env map[string]string) string {
+		t.Helper()
+		job, err := e.Execute(&storage.ExecuteRequest{Command: "env", Env: env, EnvMode: mode})
+		require.NoError(t, err)
+		finished, err := e.WaitJob(context.Background(), job.ID)
+		require.NoError(t, err)
+		require.Equal(t, storage.StatusCompleted, finished.Status, finished.Error)
+		return finished.Stdout
+	}
+
+	output := run("", map[string]string{"SCT_TEST_REQUEST": "request", "SCT_TEST_BASE": "overridden"})
+	assert.Contains(t, output, "SCT_TEST_INHERITED=agent\n", "merge is the default")
+	assert.Contains(t, output, "SCT_TEST_BASE=overridden\n")
+	assert.Contains(t, output, "SCT_TEST_REQUEST=request\n")
+	assert.Contains(t, output, "PATH=", "the agent environment is kept when setting a variable")
+	assert.NotContains(t, output, "SCT_TEST_DENIED")
+
+	output = run(EnvModeInherit, nil)
+	assert.Contains(t, output, "SCT_TEST_INHERITED=agent\n")
+	assert.Contains(t, output, "SCT_TEST_BASE=base\n")
+	assert.NotContains(t, output, "SCT_TEST_DENIED")
+
+	output = run(EnvModeClean, map[string]string{"SCT_TEST_REQUEST": "request"})
+	assert.Equal(t, "SCT_TEST_BASE=base\nSCT_TEST_REQUEST=request\n", output)
+
+	_, err := e.Execute(&storage.ExecuteRequest{Command: "env", Env: map[string]string{"A": "b"}, EnvMode: EnvModeInherit})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "env", EnvMode: "replace"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestEnvMasking(t *testing.T) {
+	e := NewExecutor(Config{
+		MaxConcurrentJobs: 1,
+		OutputDir:         t.TempDir(),
+		Env:               EnvConfig{Sensitive: []string{"*password*", "*_TOKEN"}},
+	}, storage.NewMemory())
+
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command: "sh",
+		Args:    []string{"-c", "echo $DB_PASSWORD $GITHUB_TOKEN $DB_USER"},
+		Env:     map[string]string{"DB_PASSWORD": "hunter2", "GITHUB_TOKEN": "ghp_123", "DB_USER": "scylla"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_PASSWORD": maskedValue, "GITHUB_TOKEN": maskedValue, "DB_USER": "scylla"}, job.Env)
+
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2 ghp_123 scylla\n", finished.Stdout, "the command gets the real values")
+
+	stored, err := e.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, maskedValue, stored.Env["DB_PASSWORD"])
+}
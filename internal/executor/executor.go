@@ -2,74 +2,381 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/scylladb/sct-agent/internal/metrics"
 	"github.com/scylladb/sct-agent/internal/storage"
 )
 
+// ErrInvalidRequest is returned when a command cannot be executed because of invalid request parameters
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrQueueFull is returned when the maximum number of queued jobs is reached
+var ErrQueueFull = errors.New("job queue is full")
+
+// Config holds the executor settings
+type Config struct {
+	MaxConcurrentJobs     int
+	DefaultTimeoutSeconds int
+	// MaxQueuedJobs limits the number of jobs waiting for a worker, 0 means unlimited. Jobs waiting out the
+	// backoff of a retry are not counted.
+	MaxQueuedJobs int
+	// IdempotencyWindow is how long a request ID submitted again by the same key returns the existing job,
+	// 0 disables deduplication
+	IdempotencyWindow time.Duration
+	// OutputDir is where the full output of jobs exceeding their output limit is spilled to
+	OutputDir string
+	// OutputLimitBytes is the default amount of output kept in memory per stream, 0 means unlimited
+	OutputLimitBytes int64
+	// StarvationTimeout is how long a job can wait in the queue before it is served ahead of higher priority jobs
+	StarvationTimeout time.Duration
+	// KillSignal is the default signal sent to the process group of a cancelled or timed out job
+	KillSignal string
+	// GracePeriod is the default time between KillSignal and SIGKILL
+	GracePeriod time.Duration
+	// Metrics collects job statistics, may be nil
+	Metrics *metrics.Metrics
+	// Callbacks configures the delivery of finished jobs to webhooks
+	Callbacks CallbackConfig
+	// MaxSessions limits the number of concurrent interactive sessions, 0 means unlimited
+	MaxSessions int
+	// SessionIdleTimeout is the default time after which a session without input or output is terminated
+	SessionIdleTimeout time.Duration
+	// Retention selects the finished jobs pruned by Cleanup
+	Retention storage.RetentionPolicy
+	// Cgroups runs every job in its own cgroup, which applies the resource limits of the job, may be nil
+	Cgroups *Cgroups
+	// Env configures the environment of jobs
+	Env EnvConfig
+}
+
 type Executor struct {
+	config        Config
 	maxConcurrent int
-	semaphore     chan struct{}
+	queue         *jobQueue
 	storage       storage.Storage
 	mutex         sync.RWMutex
 	cancelFuncs   map[string]context.CancelFunc
+	outputs       map[string]*jobOutput
+	stdins        map[string]*stdinSource
+	callbacks     map[string]*storage.Callback
+	// environments holds the environment of unfinished jobs, whose env is recorded with sensitive values masked
+	environments map[string][]string
+	// done holds a channel per unfinished job, closed once the job reaches a terminal state
+	done map[string]chan struct{}
+	// pipelines holds running pipelines and finished ones until all of their jobs are pruned
+	pipelines map[string]*pipeline
+	running   int
+	// sessions is the number of interactive sessions, which do not occupy workers
+	sessions int
+	// requestMutex serializes the submission of requests with a request ID and guards requests
+	requestMutex sync.Mutex
+	// requests indexes the jobs by request ID within the idempotency window
+	requests map[requestKey]requestEntry
+
+	callbackClient *http.Client
+	deliveries     sync.WaitGroup
+	// closing is closed when the executor shuts down
+	closing chan struct{}
 }
 
-func NewExecutor(maxConcurrent int, storage storage.Storage) *Executor {
-	return &Executor{
-		maxConcurrent: maxConcurrent,
-		semaphore:     make(chan struct{}, maxConcurrent),
-		storage:       storage,
+func NewExecutor(config Config, store storage.Storage) *Executor {
+	if config.DefaultTimeoutSeconds <= 0 {
+		config.DefaultTimeoutSeconds = 1800
+	}
+	if config.KillSignal == "" {
+		config.KillSignal = "SIGTERM"
+	}
+	if config.Callbacks.MaxAttempts <= 0 {
+		config.Callbacks.MaxAttempts = 5
+	}
+	if config.Callbacks.InitialBackoff <= 0 {
+		config.Callbacks.InitialBackoff = time.Second
+	}
+	if config.Callbacks.Timeout <= 0 {
+		config.Callbacks.Timeout = 10 * time.Second
+	}
+	if config.Env.DefaultMode == "" {
+		config.Env.DefaultMode = EnvModeMerge
+	}
+	if config.SessionIdleTimeout <= 0 {
+		config.SessionIdleTimeout = 15 * time.Minute
+	}
+
+	e := &Executor{
+		config:        config,
+		maxConcurrent: config.MaxConcurrentJobs,
+		queue:         newJobQueue(config.StarvationTimeout),
+		storage:       store,
 		cancelFuncs:   make(map[string]context.CancelFunc),
+		outputs:       make(map[string]*jobOutput),
+		stdins:        make(map[string]*stdinSource),
+		callbacks:     make(map[string]*storage.Callback),
+		environments:  make(map[string][]string),
+		done:          make(map[string]chan struct{}),
+		pipelines:     make(map[string]*pipeline),
+		requests:      make(map[requestKey]requestEntry),
+
+		callbackClient: &http.Client{Timeout: config.Callbacks.Timeout},
+		closing:        make(chan struct{}),
 	}
+
+	config.Metrics.RegisterExecutor(e.QueueLength, e.RunningJobs)
+	e.loadRequests()
+
+	for i := 0; i < e.maxConcurrent; i++ {
+		go e.worker()
+	}
+
+	return e
 }
 
+func (e *Executor) worker() {
+	for {
+		job := e.queue.pop()
+		if job == nil {
+			return
+		}
+		e.executeJob(job)
+	}
+}
+
+// Execute queues the command of the request. A request whose request ID was already submitted by the same key
+// within the idempotency window is not executed again, the existing job is returned with ErrDuplicateRequest.
 func (e *Executor) Execute(req *storage.ExecuteRequest) (*storage.Job, error) {
+	if len(req.RequestID) > maxRequestIDLength {
+		return nil, fmt.Errorf("%w: request_id is longer than %d characters", ErrInvalidRequest, maxRequestIDLength)
+	}
+	if req.RequestID != "" && e.config.IdempotencyWindow > 0 {
+		e.requestMutex.Lock()
+		defer e.requestMutex.Unlock()
+
+		existing, err := e.findRequest(req)
+		if existing != nil || err != nil {
+			return existing, err
+		}
+	}
+
+	if e.config.MaxQueuedJobs > 0 && e.queue.ready() >= e.config.MaxQueuedJobs {
+		return nil, fmt.Errorf("%w: %d jobs are waiting", ErrQueueFull, e.config.MaxQueuedJobs)
+	}
+
+	job, stdin, err := e.newJob(req)
+	if err != nil {
+		return nil, err
+	}
+	// the queued job belongs to the worker executing it
+	submitted := *job
+	if err := e.submit(job, req, stdin); err != nil {
+		return nil, err
+	}
+	if req.RequestID != "" && e.config.IdempotencyWindow > 0 {
+		e.recordRequest(&submitted)
+	}
+	return &submitted, nil
+}
+
+// newJob validates the request and returns its job, which is not submitted yet, together with its stdin
+func (e *Executor) newJob(req *storage.ExecuteRequest) (*storage.Job, *stdinSource, error) {
+	stdin, err := newStdinSource(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	timeout := req.Timeout
 	if timeout == 0 {
-		timeout = 1800
+		timeout = e.config.DefaultTimeoutSeconds
+	}
+
+	killSignal, gracePeriod, err := e.terminationSettings(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outputLimit := req.OutputLimit
+	if outputLimit == 0 {
+		outputLimit = e.config.OutputLimitBytes
 	}
 
 	priority := req.Priority
 	if priority == "" {
-		priority = "normal"
+		priority = PriorityNormal
+	}
+	if !validPriority(priority) {
+		return nil, nil, fmt.Errorf("%w: unknown priority %q, expected one of: %s", ErrInvalidRequest, priority, strings.Join(priorities, ", "))
+	}
+
+	if err := e.config.Cgroups.validate(req.Limits); err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateRetryPolicy(req.Retry); err != nil {
+		return nil, nil, err
+	}
+
+	envMode, err := e.envMode(req.EnvMode, req.Env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callback := req.Callback
+	if callback == nil {
+		callback = e.config.Callbacks.Default
+	}
+	if callback != nil {
+		if err := ValidateCallback(callback); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	job := &storage.Job{
-		ID:         uuid.New().String(),
-		Command:    req.Command,
-		Args:       req.Args,
-		WorkingDir: req.WorkingDir,
-		Env:        req.Env,
-		Timeout:    timeout,
-		Priority:   priority,
-		Tags:       req.Tags,
-		Status:     storage.StatusQueued,
-		CreatedAt:  time.Now(),
+		ID:          uuid.New().String(),
+		Command:     req.Command,
+		Args:        req.Args,
+		WorkingDir:  req.WorkingDir,
+		Env:         e.MaskEnv(req.Env),
+		EnvMode:     envMode,
+		Timeout:     timeout,
+		Priority:    priority,
+		Tags:        req.Tags,
+		SubmittedBy: req.SubmittedBy,
+		RequestID:   req.RequestID,
+		User:        req.User,
+		Group:       req.Group,
+		Groups:      req.Groups,
+		Limits:      req.Limits,
+		OutputLimit: outputLimit,
+		KillSignal:  killSignal,
+		GracePeriod: gracePeriod,
+		Retry:       req.Retry,
+		Status:      storage.StatusQueued,
+		CreatedAt:   time.Now(),
+	}
+	if callback != nil {
+		job.Callback = &storage.CallbackDelivery{URL: callback.URL, Status: storage.CallbackPending}
+	}
+
+	// the user and groups are resolved again when the job starts, this only rejects unknown ones early
+	if _, err := jobCredential(job); err != nil {
+		return nil, nil, err
+	}
+
+	return job, stdin, nil
+}
+
+// submit saves the job created by newJob from req and queues it, the job must not be accessed afterwards
+func (e *Executor) submit(job *storage.Job, req *storage.ExecuteRequest, stdin *stdinSource) error {
+	callback := req.Callback
+	if callback == nil {
+		callback = e.config.Callbacks.Default
 	}
 
 	if err := e.storage.Save(job); err != nil {
-		return nil, fmt.Errorf("failed to save job: %w", err)
+		return fmt.Errorf("failed to save job: %w", err)
 	}
 
-	go e.executeJob(job)
+	e.mutex.Lock()
+	e.outputs[job.ID] = newJobOutput(job.OutputLimit, e.config.OutputDir, job.ID)
+	if stdin != nil {
+		e.stdins[job.ID] = stdin
+	}
+	if callback != nil {
+		e.callbacks[job.ID] = callback
+	}
+	e.environments[job.ID] = e.environment(req.Env, job.EnvMode)
+	e.done[job.ID] = make(chan struct{})
+	e.mutex.Unlock()
 
-	return job, nil
+	e.config.Metrics.JobSubmitted(job)
+	e.queue.push(job)
+
+	return nil
+}
+
+// terminationSettings returns the kill signal and the grace period of the request, falling back to the defaults
+func (e *Executor) terminationSettings(req *storage.ExecuteRequest) (string, int, error) {
+	killSignal := req.KillSignal
+	if killSignal == "" {
+		killSignal = e.config.KillSignal
+	}
+	if _, err := ParseSignal(killSignal); err != nil {
+		return "", 0, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	gracePeriod := req.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = int(e.config.GracePeriod.Seconds())
+	}
+	if gracePeriod < 0 {
+		return "", 0, fmt.Errorf("%w: grace_period must not be negative", ErrInvalidRequest)
+	}
+	return killSignal, gracePeriod, nil
 }
 
+// GetJob returns the job, queued jobs have their current position in the queue set
 func (e *Executor) GetJob(id string) (*storage.Job, error) {
-	if job, exists := e.storage.Get(id); exists {
-		return job, nil
+	job, exists := e.storage.Get(id)
+	if !exists {
+		return nil, fmt.Errorf("job not found")
+	}
+
+	if job.Status == storage.StatusQueued {
+		if position := e.queue.position(id); position > 0 {
+			queued := *job
+			queued.QueuePosition = position
+			return &queued, nil
+		}
+	}
+	return job, nil
+}
+
+// WaitJob blocks until the job reaches a terminal state or ctx is done, then returns the job
+func (e *Executor) WaitJob(ctx context.Context, id string) (*storage.Job, error) {
+	e.mutex.RLock()
+	done, exists := e.done[id]
+	e.mutex.RUnlock()
+
+	// jobs without a channel have already finished or are unknown
+	if exists {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
 	}
-	return nil, fmt.Errorf("job not found")
+	return e.GetJob(id)
+}
+
+// QueueLength returns the number of jobs waiting for a worker
+func (e *Executor) QueueLength() int {
+	return e.queue.len()
+}
+
+// MaxConcurrentJobs returns the number of workers
+func (e *Executor) MaxConcurrentJobs() int {
+	return e.maxConcurrent
+}
+
+// IdempotencyWindow returns how long request IDs are deduplicated, 0 if deduplication is disabled
+func (e *Executor) IdempotencyWindow() time.Duration {
+	return e.config.IdempotencyWindow
+}
+
+// RunningJobs returns the number of jobs currently executed by workers
+func (e *Executor) RunningJobs() int {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.running
 }
 
 func (e *Executor) CancelJob(id string) error {
@@ -89,6 +396,7 @@ func (e *Executor) CancelJob(id string) error {
 		cancelFunc()
 		delete(e.cancelFuncs, id)
 	}
+	dequeued := e.queue.remove(id)
 
 	now := time.Now()
 	job.Status = storage.StatusCancelled
@@ -97,7 +405,92 @@ func (e *Executor) CancelJob(id string) error {
 		job.DurationMs = time.Since(*job.StartedAt).Milliseconds()
 	}
 
-	return e.storage.Save(job)
+	err := e.storage.Save(job)
+	if dequeued {
+		// the job never reaches a worker, which would otherwise release its resources
+		e.releaseJobLocked(id)
+		e.finishJobLocked(job)
+	}
+	return err
+}
+
+// FollowOutput streams stdout and stderr of the job starting at the given byte offsets. The returned
+// channel is closed once the job has finished and all of its output was delivered, or when ctx is done.
+func (e *Executor) FollowOutput(ctx context.Context, id string, stdoutOffset, stderrOffset int64) (<-chan OutputChunk, error) {
+	e.mutex.RLock()
+	output, live := e.outputs[id]
+	e.mutex.RUnlock()
+
+	if !live {
+		job, exists := e.storage.Get(id)
+		if !exists {
+			return nil, fmt.Errorf("job not found")
+		}
+		output = e.finishedOutput(job)
+	}
+
+	chunks := make(chan OutputChunk)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		output.stdout.follow(ctx, StreamStdout, stdoutOffset, chunks)
+	}()
+	go func() {
+		defer wg.Done()
+		output.stderr.follow(ctx, StreamStderr, stderrOffset, chunks)
+	}()
+	go func() {
+		wg.Wait()
+		close(chunks)
+	}()
+
+	return chunks, nil
+}
+
+// ReadOutput returns up to length bytes of the given output stream of the job starting at offset,
+// together with the total size of the stream
+func (e *Executor) ReadOutput(id, stream string, offset, length int64) ([]byte, int64, error) {
+	e.mutex.RLock()
+	output, live := e.outputs[id]
+	e.mutex.RUnlock()
+
+	if !live {
+		job, exists := e.storage.Get(id)
+		if !exists {
+			return nil, 0, fmt.Errorf("job not found")
+		}
+		output = e.finishedOutput(job)
+	}
+
+	buffer, err := output.stream(stream)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, start, _, _ := buffer.read(offset, length)
+	if start != offset && len(data) > 0 {
+		return nil, buffer.Size(), fmt.Errorf("requested output range is no longer available")
+	}
+	return data, buffer.Size(), nil
+}
+
+// finishedOutput returns the output of a job which is no longer running, preferring the spilled
+// full output over the truncated one kept on the job
+func (e *Executor) finishedOutput(job *storage.Job) *jobOutput {
+	open := func(stream, data string) *outputBuffer {
+		if job.OutputTruncated {
+			if buffer, err := openSpilledOutputBuffer(spillPath(e.config.OutputDir, job.ID, stream)); err == nil {
+				return buffer
+			}
+		}
+		return newClosedOutputBuffer(data)
+	}
+
+	return &jobOutput{
+		stdout: open(StreamStdout, job.Stdout),
+		stderr: open(StreamStderr, job.Stderr),
+	}
 }
 
 func (e *Executor) ListJobs(status storage.JobStatus, limit, offset int, since *time.Time) ([]*storage.Job, int, error) {
@@ -115,31 +508,51 @@ func (e *Executor) GetStats() map[string]int {
 	}
 }
 
+// executeJob runs the next attempt of the job, which is queued again if its retry policy retries the attempt
 func (e *Executor) executeJob(job *storage.Job) {
-	e.semaphore <- struct{}{}
-	defer func() { <-e.semaphore }()
-
+	// the timeout applies to every attempt of the job
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(job.Timeout)*time.Second)
 	defer cancel()
 
 	e.mutex.Lock()
-	e.cancelFuncs[job.ID] = cancel
-	e.mutex.Unlock()
-
-	defer func() {
-		e.mutex.Lock()
-		delete(e.cancelFuncs, job.ID)
+	// the job may have been cancelled after a worker took it from the queue, CancelJob saves the cancelled job
+	if stored, exists := e.storage.Get(job.ID); exists && stored.Status == storage.StatusCancelled {
+		e.releaseJobLocked(job.ID)
+		e.finishJobLocked(stored)
 		e.mutex.Unlock()
-	}()
-
+		return
+	}
+	e.cancelFuncs[job.ID] = cancel
+	e.running++
 	now := time.Now()
 	job.Status = storage.StatusRunning
-	job.StartedAt = &now
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	if job.Retry != nil {
+		job.AttemptStartedAt = &now
+	}
+	job.NextAttemptAt = nil
+	e.mutex.Unlock()
+
 	e.storage.Save(job)
+	if job.Attempt == 0 {
+		e.config.Metrics.JobStarted(job)
+		e.logJobStart(job)
+	}
 
-	e.logJobStart(job)
+	retryAt, retry := e.runAttempt(ctx, job)
 
-	e.runCommand(ctx, job)
+	e.mutex.Lock()
+	delete(e.cancelFuncs, job.ID)
+	e.running--
+	e.mutex.Unlock()
+
+	if retry {
+		// the job leaves the worker free during the backoff
+		e.queue.pushAt(job, retryAt)
+		return
+	}
 
 	completedAt := time.Now()
 	job.CompletedAt = &completedAt
@@ -148,26 +561,119 @@ func (e *Executor) executeJob(job *storage.Job) {
 	e.logJobCompletion(job)
 
 	e.storage.Save(job)
+
+	// followers are released only after the final job state is saved
+	e.mutex.Lock()
+	e.releaseJobLocked(job.ID)
+	e.finishJobLocked(job)
+	e.mutex.Unlock()
+}
+
+// releaseJobLocked frees resources held for a finished job, e.mutex must be held by the caller
+func (e *Executor) releaseJobLocked(id string) {
+	if output, exists := e.outputs[id]; exists {
+		output.close()
+		delete(e.outputs, id)
+	}
+	if stdin, exists := e.stdins[id]; exists {
+		stdin.release()
+		delete(e.stdins, id)
+	}
+	delete(e.environments, id)
+}
+
+// finishJobLocked records a job which reached a terminal state and starts the delivery of its callback,
+// e.mutex must be held by the caller
+func (e *Executor) finishJobLocked(job *storage.Job) {
+	e.config.Metrics.JobFinished(job)
+
+	if done, exists := e.done[job.ID]; exists {
+		close(done)
+		delete(e.done, job.ID)
+	}
+
+	if callback, exists := e.callbacks[job.ID]; exists {
+		delete(e.callbacks, job.ID)
+		e.deliveries.Add(1)
+		go e.deliverCallback(job, callback)
+	}
 }
 
 func (e *Executor) runCommand(ctx context.Context, job *storage.Job) {
+	e.mutex.RLock()
+	output, exists := e.outputs[job.ID]
+	stdin := e.stdins[job.ID]
+	environment, hasEnvironment := e.environments[job.ID]
+	e.mutex.RUnlock()
+	if !exists {
+		output = newJobOutput(job.OutputLimit, e.config.OutputDir, job.ID)
+	}
+	if !hasEnvironment {
+		// the values of sensitive variables are lost for jobs which were not submitted through Execute
+		environment = e.environment(job.Env, job.EnvMode)
+	}
+
 	cmd := exec.CommandContext(ctx, job.Command, job.Args...)
 
 	if job.WorkingDir != "" {
 		cmd.Dir = job.WorkingDir
 	}
 
-	if len(job.Env) > 0 {
-		cmd.Env = make([]string, 0, len(job.Env))
-		for key, value := range job.Env {
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	cmd.Env = environment
+
+	if stdin != nil {
+		reader, err := stdin.open()
+		if err != nil {
+			job.Status = storage.StatusFailed
+			job.Error = fmt.Sprintf("failed to open stdin: %v", err)
+			exitCode := -1
+			job.ExitCode = &exitCode
+			return
 		}
+		defer reader.Close()
+		cmd.Stdin = reader
+	}
+
+	killSignal, err := ParseSignal(job.KillSignal)
+	if err != nil {
+		killSignal = syscall.SIGTERM
+	}
+	gracePeriod := time.Duration(job.GracePeriod) * time.Second
+	group := newProcessGroup(cmd, killSignal, gracePeriod)
+
+	credential, err := jobCredential(job)
+	if err != nil {
+		job.Status = storage.StatusFailed
+		job.Error = fmt.Sprintf("failed to resolve credential: %v", err)
+		exitCode := -1
+		job.ExitCode = &exitCode
+		return
 	}
+	cmd.SysProcAttr.Credential = credential
 
-	var stdout, stderr []byte
-	var err error
+	cgroup, err := e.config.Cgroups.create(job)
+	if err != nil {
+		job.Status = storage.StatusFailed
+		job.Error = fmt.Sprintf("failed to create cgroup: %v", err)
+		exitCode := -1
+		job.ExitCode = &exitCode
+		return
+	}
+	defer cgroup.remove()
+
+	releaseCgroup, err := cgroup.attach(cmd)
+	if err != nil {
+		job.Status = storage.StatusFailed
+		job.Error = fmt.Sprintf("failed to open cgroup: %v", err)
+		exitCode := -1
+		job.ExitCode = &exitCode
+		return
+	}
+	defer releaseCgroup()
 
-	stdoutPipe, err := cmd.StdoutPipe()
+	// the pipes are handled here instead of by exec.Cmd, so that Wait returns as soon as the command
+	// exits even if its background processes keep the pipes open
+	stdoutReader, stdoutWriter, err := os.Pipe()
 	if err != nil {
 		job.Status = storage.StatusFailed
 		job.Error = fmt.Sprintf("failed to create stdout pipe: %v", err)
@@ -175,17 +681,27 @@ func (e *Executor) runCommand(ctx context.Context, job *storage.Job) {
 		job.ExitCode = &exitCode
 		return
 	}
+	defer stdoutReader.Close()
 
-	stderrPipe, err := cmd.StderrPipe()
+	stderrReader, stderrWriter, err := os.Pipe()
 	if err != nil {
+		stdoutWriter.Close()
 		job.Status = storage.StatusFailed
 		job.Error = fmt.Sprintf("failed to create stderr pipe: %v", err)
 		exitCode := -1
 		job.ExitCode = &exitCode
 		return
 	}
+	defer stderrReader.Close()
 
-	if err := cmd.Start(); err != nil {
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	err = cmd.Start()
+	// the child process holds its own copies of the write ends
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
 		job.Status = storage.StatusFailed
 		job.Error = fmt.Sprintf("failed to start command: %v", err)
 		exitCode := -1
@@ -198,20 +714,35 @@ func (e *Executor) runCommand(ctx context.Context, job *storage.Job) {
 
 	go func() {
 		defer wg.Done()
-		stdout, _ = io.ReadAll(stdoutPipe)
+		io.Copy(output.stdout, stdoutReader)
 	}()
 
 	go func() {
 		defer wg.Done()
-		stderr, _ = io.ReadAll(stderrPipe)
+		io.Copy(output.stderr, stderrReader)
 	}()
 
-	wg.Wait()
-
 	err = cmd.Wait()
 
-	job.Stdout = string(stdout)
-	job.Stderr = string(stderr)
+	// give the remaining processes of the group the grace period (and the SIGKILL escalation, if the job
+	// was terminated) to close the pipes, then stop reading
+	if !waitGroupTimeout(&wg, gracePeriod+time.Second) {
+		slog.Warn("Output pipes held open by background processes, not reading further output",
+			"job_id", job.ID[:8])
+		stdoutReader.Close()
+		stderrReader.Close()
+		wg.Wait()
+	}
+	group.stop()
+	job.TerminationSignal = group.terminationSignal()
+	job.Resources = cgroup.usage()
+	recordRusage(job, cmd.ProcessState)
+
+	job.Stdout = output.stdout.String()
+	job.Stderr = output.stderr.String()
+	job.StdoutBytes = output.stdout.Size()
+	job.StderrBytes = output.stderr.Size()
+	job.OutputTruncated = output.stdout.Truncated() || output.stderr.Truncated()
 
 	if err != nil {
 		job.Status = storage.StatusFailed
@@ -229,10 +760,12 @@ func (e *Executor) runCommand(ctx context.Context, job *storage.Job) {
 		exitCode := 0
 		job.ExitCode = &exitCode
 	}
+	markOOMKilled(job)
 
 	if ctx.Err() == context.DeadlineExceeded {
 		job.Status = storage.StatusFailed
 		job.Error = "command timed out"
+		job.FailureReason = storage.FailureTimeout
 		if job.Stderr == "" {
 			job.Stderr = "Command execution timed out"
 		}
@@ -245,7 +778,34 @@ func (e *Executor) runCommand(ctx context.Context, job *storage.Job) {
 	}
 }
 
+// waitGroupTimeout waits for wg and returns false if it did not finish within timeout
+func waitGroupTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Shutdown stops starting queued jobs, cancels the running jobs and the jobs waiting to be retried and waits
+// until the running jobs finished and their callbacks were delivered, or ctx is done
 func (e *Executor) Shutdown(ctx context.Context) error {
+	// queued jobs are not started anymore and failed callback deliveries are not retried
+	e.queue.close()
+	close(e.closing)
+
+	// jobs waiting out the backoff of a retry would otherwise stay queued forever
+	for _, id := range e.queue.delayed() {
+		e.CancelJob(id)
+	}
+
 	e.mutex.Lock()
 	for _, cancel := range e.cancelFuncs {
 		cancel()
@@ -258,7 +818,7 @@ func (e *Executor) Shutdown(ctx context.Context) error {
 
 	for {
 		if e.storage.CountByStatus(storage.StatusRunning) == 0 {
-			return nil
+			break
 		}
 		select {
 		case <-ctx.Done():
@@ -266,6 +826,28 @@ func (e *Executor) Shutdown(ctx context.Context) error {
 		case <-ticker.C:
 		}
 	}
+
+	// the last deliveries are attempted once more, as jobs cancelled above have just finished
+	if !waitGroupContext(ctx, &e.deliveries) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// waitGroupContext waits for wg and returns false if ctx was done first
+func waitGroupContext(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func (e *Executor) logJobStart(job *storage.Job) {
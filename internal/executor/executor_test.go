@@ -0,0 +1,209 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func newTestExecutor(t *testing.T, maxConcurrentJobs int) *Executor {
+	t.Helper()
+
+	e := NewExecutor(Config{MaxConcurrentJobs: maxConcurrentJobs, OutputDir: t.TempDir()}, storage.NewMemory())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		e.Shutdown(ctx)
+	})
+	return e
+}
+
+func TestWaitJob(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "sleep", Args: []string{"0.2"}})
+	require.NoError(t, err)
+
+	start := time.Now()
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCompleted, finished.Status)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	// finished jobs are returned right away
+	finished, err = e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCompleted, finished.Status)
+
+	_, err = e.WaitJob(context.Background(), "unknown")
+	assert.Error(t, err)
+}
+
+func TestWaitJobTimeout(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "sleep", Args: []string{"10"}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	current, err := e.WaitJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.False(t, current.Status.IsTerminal())
+
+	// cancelling a queued or running job finishes the wait as well
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		e.CancelJob(job.ID)
+	}()
+	cancelled, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCancelled, cancelled.Status)
+}
+
+func TestCleanup(t *testing.T) {
+	outputDir := t.TempDir()
+	e := NewExecutor(Config{
+		MaxConcurrentJobs: 1,
+		OutputDir:         outputDir,
+		OutputLimitBytes:  16,
+		Retention:         storage.RetentionPolicy{MaxJobs: 1},
+	}, storage.NewMemory())
+	defer e.Shutdown(context.Background())
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		job, err := e.Execute(&storage.ExecuteRequest{Command: "seq", Args: []string{"1000"}})
+		require.NoError(t, err)
+		_, err = e.WaitJob(context.Background(), job.ID)
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+	}
+
+	assert.Equal(t, 1, e.Cleanup())
+
+	_, err := e.GetJob(ids[0])
+	assert.Error(t, err, "the oldest job is pruned")
+	_, err = os.Stat(spillPath(outputDir, ids[0], StreamStdout))
+	assert.True(t, os.IsNotExist(err), "the spilled output of the pruned job is removed")
+
+	_, err = os.Stat(spillPath(outputDir, ids[1], StreamStdout))
+	assert.NoError(t, err)
+}
+
+func TestRunAsUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	e := newTestExecutor(t, 1)
+
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "id", User: "nobody", Group: "daemon", Groups: []string{"daemon", "4"}})
+	require.NoError(t, err)
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusCompleted, finished.Status, finished.Error)
+	assert.Contains(t, finished.Stdout, "uid=65534(nobody) gid=1(daemon) groups=1(daemon),4(adm)")
+	assert.Equal(t, "nobody", finished.User)
+
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "id", User: "no-such-user"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestResourceUsage(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command: "sh",
+		Args:    []string{"-c", "i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done"},
+	})
+	require.NoError(t, err)
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	require.Equal(t, storage.StatusCompleted, finished.Status)
+
+	require.NotNil(t, finished.Resources)
+	assert.Positive(t, finished.Resources.UserCPUMs+finished.Resources.SystemCPUMs)
+	assert.Positive(t, finished.Resources.MaxRSSBytes)
+	assert.Zero(t, finished.Resources.CPUTimeMs, "cgroup accounting is not configured")
+}
+
+func TestMaxQueuedJobs(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	e.config.MaxQueuedJobs = 1
+
+	running, err := e.Execute(&storage.ExecuteRequest{Command: "sleep", Args: []string{"5"}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return e.QueueLength() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	queued, err := e.Execute(&storage.ExecuteRequest{Command: "true"})
+	require.NoError(t, err)
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "true"})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	require.NoError(t, e.CancelJob(queued.ID))
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "true"})
+	assert.NoError(t, err, "cancelled jobs leave the queue")
+	require.NoError(t, e.CancelJob(running.ID))
+}
+
+func TestRequestIDDeduplication(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	e.config.IdempotencyWindow = time.Hour
+
+	req := &storage.ExecuteRequest{Command: "echo", Args: []string{"hello"}, RequestID: "abc", SubmittedBy: "sct-runner"}
+	job, err := e.Execute(req)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", job.RequestID)
+
+	again, err := e.Execute(&storage.ExecuteRequest{Command: "echo", Args: []string{"hello"}, RequestID: "abc", SubmittedBy: "sct-runner"})
+	assert.ErrorIs(t, err, ErrDuplicateRequest)
+	require.NotNil(t, again)
+	assert.Equal(t, job.ID, again.ID)
+
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "echo", Args: []string{"bye"}, RequestID: "abc", SubmittedBy: "sct-runner"})
+	assert.ErrorIs(t, err, ErrInvalidRequest, "a request ID cannot be reused for a different command")
+
+	other, err := e.Execute(&storage.ExecuteRequest{Command: "echo", Args: []string{"hello"}, RequestID: "abc", SubmittedBy: "monitoring"})
+	require.NoError(t, err, "request IDs are scoped to the submitting key")
+	assert.NotEqual(t, job.ID, other.ID)
+
+	e.config.IdempotencyWindow = 0
+	_, err = e.Execute(req)
+	assert.NoError(t, err, "deduplication is disabled")
+}
+
+func TestRequestIDIndex(t *testing.T) {
+	store := storage.NewMemory()
+	now := time.Now()
+	require.NoError(t, store.Save(&storage.Job{ID: "recent", Command: "echo", RequestID: "abc", SubmittedBy: "sct-runner",
+		Status: storage.StatusCompleted, CreatedAt: now.Add(-time.Minute)}))
+	require.NoError(t, store.Save(&storage.Job{ID: "expired", Command: "echo", RequestID: "old", SubmittedBy: "sct-runner",
+		Status: storage.StatusCompleted, CreatedAt: now.Add(-2 * time.Hour)}))
+
+	e := NewExecutor(Config{MaxConcurrentJobs: 1, OutputDir: t.TempDir(), IdempotencyWindow: time.Hour}, store)
+	t.Cleanup(func() { e.Shutdown(context.Background()) })
+
+	existing, err := e.Execute(&storage.ExecuteRequest{Command: "echo", RequestID: "abc", SubmittedBy: "sct-runner"})
+	assert.ErrorIs(t, err, ErrDuplicateRequest, "request IDs of stored jobs are indexed at startup")
+	require.NotNil(t, existing)
+	assert.Equal(t, "recent", existing.ID)
+
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "echo", RequestID: "old", SubmittedBy: "sct-runner"})
+	require.NoError(t, err, "request IDs before the idempotency window are not indexed")
+	_, err = e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Delete("recent"))
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "echo", RequestID: "abc", SubmittedBy: "sct-runner"})
+	assert.NoError(t, err, "request IDs are forgotten with their jobs")
+
+	e.config.IdempotencyWindow = time.Nanosecond
+	assert.Equal(t, 2, e.pruneRequests())
+	assert.Empty(t, e.requests)
+}
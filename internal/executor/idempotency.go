@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// ErrDuplicateRequest is returned together with the existing job when a request ID is submitted again within
+// the idempotency window
+var ErrDuplicateRequest = errors.New("duplicate request")
+
+// maxRequestIDLength limits the length of client supplied request IDs
+const maxRequestIDLength = 255
+
+// requestKey identifies a request ID, which is scoped to the submitting key
+type requestKey struct {
+	submittedBy string
+	requestID   string
+}
+
+// requestEntry is the job submitted for a request ID
+type requestEntry struct {
+	jobID     string
+	createdAt time.Time
+}
+
+// loadRequests indexes the request IDs of the stored jobs submitted within the idempotency window, so they are
+// deduplicated across restarts
+func (e *Executor) loadRequests() {
+	if e.config.IdempotencyWindow <= 0 {
+		return
+	}
+	since := time.Now().Add(-e.config.IdempotencyWindow)
+	jobs, _, err := e.storage.List("", 0, 0, &since)
+	if err != nil {
+		slog.Warn("Failed to load request IDs", "error", err)
+		return
+	}
+
+	e.requestMutex.Lock()
+	defer e.requestMutex.Unlock()
+	for _, job := range jobs {
+		if job.RequestID != "" {
+			e.requests[requestKey{job.SubmittedBy, job.RequestID}] = requestEntry{job.ID, job.CreatedAt}
+		}
+	}
+}
+
+// findRequest returns the job submitted by the same key with the request ID of req within the idempotency window,
+// or nil if there is none. e.requestMutex must be held by the caller, so concurrent duplicates are not both
+// executed.
+func (e *Executor) findRequest(req *storage.ExecuteRequest) (*storage.Job, error) {
+	key := requestKey{req.SubmittedBy, req.RequestID}
+	entry, ok := e.requests[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Since(entry.createdAt) > e.config.IdempotencyWindow {
+		delete(e.requests, key)
+		return nil, nil
+	}
+	existing, err := e.GetJob(entry.jobID)
+	if err != nil {
+		// the job was pruned, which forgets its request ID
+		delete(e.requests, key)
+		return nil, nil
+	}
+	if existing.Command != req.Command || !slices.Equal(existing.Args, req.Args) {
+		return nil, fmt.Errorf("%w: request_id %q was used for a different command by job %s", ErrInvalidRequest, req.RequestID, existing.ID)
+	}
+	return existing, ErrDuplicateRequest
+}
+
+// recordRequest indexes the request ID of the submitted job, e.requestMutex must be held by the caller
+func (e *Executor) recordRequest(job *storage.Job) {
+	e.requests[requestKey{job.SubmittedBy, job.RequestID}] = requestEntry{job.ID, job.CreatedAt}
+}
+
+// pruneRequests forgets the request IDs submitted before the idempotency window and returns their number
+func (e *Executor) pruneRequests() int {
+	e.requestMutex.Lock()
+	defer e.requestMutex.Unlock()
+
+	since := time.Now().Add(-e.config.IdempotencyWindow)
+	pruned := 0
+	for key, entry := range e.requests {
+		if entry.createdAt.Before(since) {
+			delete(e.requests, key)
+			pruned++
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,306 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+
+	// maxChunkSize limits the amount of output delivered to a follower at once
+	maxChunkSize = 32 * 1024
+)
+
+// OutputChunk is a piece of job output delivered to followers
+type OutputChunk struct {
+	Stream string
+	Offset int64
+	Data   []byte
+}
+
+// outputBuffer collects process output and allows readers to follow it while the process is still running.
+//
+// At most limit bytes are kept in memory: the first half of the stream (head) and its most recent half
+// (tail). Once the stream outgrows the limit, the full stream is spilled to spillPath so that any range
+// of it can still be retrieved.
+type outputBuffer struct {
+	mutex     sync.Mutex
+	limit     int64
+	spillPath string
+	head      []byte
+	tail      []byte
+	size      int64
+	file      *os.File
+	spilled   bool
+	closed    bool
+	changed   chan struct{}
+}
+
+// newOutputBuffer creates a buffer keeping up to limit bytes in memory, limit <= 0 means unlimited.
+// An empty spillPath disables spilling the full stream to disk.
+func newOutputBuffer(limit int64, spillPath string) *outputBuffer {
+	return &outputBuffer{
+		limit:     limit,
+		spillPath: spillPath,
+		changed:   make(chan struct{}),
+	}
+}
+
+// newClosedOutputBuffer returns a buffer holding the output of an already finished job
+func newClosedOutputBuffer(data string) *outputBuffer {
+	b := newOutputBuffer(0, "")
+	b.head = []byte(data)
+	b.size = int64(len(data))
+	b.Close()
+	return b
+}
+
+// openSpilledOutputBuffer returns a closed buffer reading the output of a finished job from its spill file
+func openSpilledOutputBuffer(path string) (*outputBuffer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newOutputBuffer(0, path)
+	b.spilled = true
+	b.size = info.Size()
+	b.Close()
+	return b, nil
+}
+
+func (b *outputBuffer) headLimit() int64 {
+	return b.limit - b.limit/2
+}
+
+func (b *outputBuffer) tailLimit() int64 {
+	return b.limit / 2
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.limit > 0 && !b.spilled && b.spillPath != "" && b.size+int64(len(p)) > b.limit {
+		b.startSpill()
+	}
+	if b.file != nil {
+		if _, err := b.file.Write(p); err != nil {
+			slog.Warn("Failed to spill job output, full output will not be available", "path", b.spillPath, "error", err)
+			b.file.Close()
+			b.file = nil
+			b.spilled = false
+			b.spillPath = ""
+		}
+	}
+
+	data := p
+	if b.limit <= 0 {
+		b.head = append(b.head, data...)
+	} else {
+		if room := b.headLimit() - int64(len(b.head)); room > 0 {
+			n := min(room, int64(len(data)))
+			b.head = append(b.head, data[:n]...)
+			data = data[n:]
+		}
+		b.tail = append(b.tail, data...)
+		// trim lazily to avoid copying the tail on every write
+		if tailLimit := b.tailLimit(); int64(len(b.tail)) > 2*tailLimit {
+			b.tail = append([]byte(nil), b.tail[int64(len(b.tail))-tailLimit:]...)
+		}
+	}
+	b.size += int64(len(p))
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// startSpill creates the spill file and writes the output collected so far into it.
+// Nothing has been dropped from memory yet at this point, as the limit is just being exceeded.
+func (b *outputBuffer) startSpill() {
+	if err := os.MkdirAll(filepath.Dir(b.spillPath), 0o750); err != nil {
+		slog.Warn("Failed to create output directory, full output will not be available", "path", b.spillPath, "error", err)
+		b.spillPath = ""
+		return
+	}
+	file, err := os.OpenFile(b.spillPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		slog.Warn("Failed to create output file, full output will not be available", "path", b.spillPath, "error", err)
+		b.spillPath = ""
+		return
+	}
+	if _, err := file.Write(b.head); err == nil {
+		_, err = file.Write(b.tail)
+	}
+	if err != nil {
+		slog.Warn("Failed to spill job output, full output will not be available", "path", b.spillPath, "error", err)
+		file.Close()
+		b.spillPath = ""
+		return
+	}
+	b.file = file
+	b.spilled = true
+}
+
+// Close marks the buffer as complete and wakes up all waiting readers
+func (b *outputBuffer) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+	if !b.closed {
+		b.closed = true
+		close(b.changed)
+	}
+}
+
+// String returns the output kept in memory
+func (b *outputBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return string(b.head) + string(b.keptTail())
+}
+
+// keptTail returns the tail without the excess left there by the lazy trimming
+func (b *outputBuffer) keptTail() []byte {
+	if tailLimit := b.tailLimit(); b.limit > 0 && int64(len(b.tail)) > tailLimit {
+		return b.tail[int64(len(b.tail))-tailLimit:]
+	}
+	return b.tail
+}
+
+// Size returns the total number of bytes written
+func (b *outputBuffer) Size() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.size
+}
+
+// Truncated tells whether part of the output is not kept in memory
+func (b *outputBuffer) Truncated() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.limit > 0 && b.size > b.limit
+}
+
+// read returns up to length bytes starting at offset together with the offset the data actually starts at,
+// which differs from the requested one only when that part of the output is no longer available.
+// It also returns a channel which is closed on the next change of the buffer and whether the buffer is closed.
+func (b *outputBuffer) read(offset, length int64) ([]byte, int64, <-chan struct{}, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= b.size || length <= 0 {
+		return nil, offset, b.changed, b.closed
+	}
+	length = min(length, b.size-offset)
+
+	var chunk []byte
+	tail := b.keptTail()
+	tailStart := b.size - int64(len(tail))
+	switch {
+	case offset < int64(len(b.head)):
+		chunk = b.head[offset:min(offset+length, int64(len(b.head)))]
+	case b.spilled:
+		data, err := readFileAt(b.spillPath, offset, length)
+		if err != nil {
+			slog.Warn("Failed to read job output file", "path", b.spillPath, "error", err)
+		}
+		return data, offset, b.changed, b.closed
+	default:
+		// the requested range was dropped, continue with the oldest data still kept
+		offset = max(offset, tailStart)
+		chunk = tail[offset-tailStart : min(offset-tailStart+length, int64(len(tail)))]
+	}
+
+	return append([]byte(nil), chunk...), offset, b.changed, b.closed
+}
+
+func readFileAt(path string, offset, length int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, length)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return data[:n], err
+	}
+	return data[:n], nil
+}
+
+// follow sends the buffer content starting at offset to out until the buffer is closed and drained or ctx is done
+func (b *outputBuffer) follow(ctx context.Context, stream string, offset int64, out chan<- OutputChunk) {
+	for {
+		chunk, start, changed, closed := b.read(offset, maxChunkSize)
+		if len(chunk) > 0 {
+			select {
+			case out <- OutputChunk{Stream: stream, Offset: start, Data: chunk}:
+				offset = start + int64(len(chunk))
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if closed {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// jobOutput holds the output streams of a job
+type jobOutput struct {
+	stdout *outputBuffer
+	stderr *outputBuffer
+}
+
+func newJobOutput(limit int64, outputDir, jobID string) *jobOutput {
+	return &jobOutput{
+		stdout: newOutputBuffer(limit, spillPath(outputDir, jobID, StreamStdout)),
+		stderr: newOutputBuffer(limit, spillPath(outputDir, jobID, StreamStderr)),
+	}
+}
+
+func (o *jobOutput) stream(name string) (*outputBuffer, error) {
+	switch name {
+	case StreamStdout:
+		return o.stdout, nil
+	case StreamStderr:
+		return o.stderr, nil
+	default:
+		return nil, fmt.Errorf("unknown output stream: %s", name)
+	}
+}
+
+func (o *jobOutput) close() {
+	o.stdout.Close()
+	o.stderr.Close()
+}
+
+func spillPath(outputDir, jobID, stream string) string {
+	if outputDir == "" {
+		return ""
+	}
+	return filepath.Join(outputDir, filepath.Base(jobID)+"."+stream)
+}
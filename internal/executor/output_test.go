@@ -0,0 +1,109 @@
+package executor
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputBufferUnlimited(t *testing.T) {
+	buffer := newOutputBuffer(0, "")
+
+	_, err := buffer.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = buffer.Write([]byte("world"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", buffer.String())
+	assert.Equal(t, int64(11), buffer.Size())
+	assert.False(t, buffer.Truncated())
+
+	data, start, _, closed := buffer.read(6, 100)
+	assert.Equal(t, "world", string(data))
+	assert.Equal(t, int64(6), start)
+	assert.False(t, closed)
+}
+
+func TestOutputBufferHeadAndTail(t *testing.T) {
+	buffer := newOutputBuffer(10, "")
+
+	for _, part := range []string{"0123", "4567", "89ab", "cdef", "ghij"} {
+		_, err := buffer.Write([]byte(part))
+		require.NoError(t, err)
+	}
+
+	assert.True(t, buffer.Truncated())
+	assert.Equal(t, int64(20), buffer.Size())
+	assert.Equal(t, "01234"+"fghij", buffer.String())
+
+	// the dropped middle of the stream cannot be read without a spill file
+	data, start, _, _ := buffer.read(7, 4)
+	assert.Equal(t, int64(15), start)
+	assert.Equal(t, "fghi", string(data))
+}
+
+func TestOutputBufferSpill(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output", "job.stdout")
+	buffer := newOutputBuffer(10, path)
+
+	content := strings.Repeat("0123456789", 10)
+	for i := 0; i < len(content); i += 7 {
+		_, err := buffer.Write([]byte(content[i:min(i+7, len(content))]))
+		require.NoError(t, err)
+	}
+	buffer.Close()
+
+	assert.True(t, buffer.Truncated())
+	assert.Len(t, buffer.String(), 10)
+
+	data, start, _, closed := buffer.read(42, 16)
+	assert.Equal(t, int64(42), start)
+	assert.Equal(t, content[42:58], string(data))
+	assert.True(t, closed)
+
+	spilled, err := openSpilledOutputBuffer(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), spilled.Size())
+
+	chunks := make(chan OutputChunk)
+	go func() {
+		spilled.follow(context.Background(), StreamStdout, 90, chunks)
+		close(chunks)
+	}()
+
+	var followed strings.Builder
+	for chunk := range chunks {
+		followed.Write(chunk.Data)
+	}
+	assert.Equal(t, content[90:], followed.String())
+}
+
+func TestOutputBufferFollowLive(t *testing.T) {
+	buffer := newOutputBuffer(0, "")
+
+	chunks := make(chan OutputChunk)
+	go func() {
+		buffer.follow(context.Background(), StreamStderr, 0, chunks)
+		close(chunks)
+	}()
+
+	_, err := buffer.Write([]byte("first"))
+	require.NoError(t, err)
+	chunk := <-chunks
+	assert.Equal(t, StreamStderr, chunk.Stream)
+	assert.Equal(t, "first", string(chunk.Data))
+
+	_, err = buffer.Write([]byte("second"))
+	require.NoError(t, err)
+	chunk = <-chunks
+	assert.Equal(t, int64(5), chunk.Offset)
+	assert.Equal(t, "second", string(chunk.Data))
+
+	buffer.Close()
+	_, open := <-chunks
+	assert.False(t, open)
+}
@@ -0,0 +1,428 @@
+package executor
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// maxPipelineSteps limits the number of steps of a pipeline
+const maxPipelineSteps = 100
+
+// pipeline is a running or finished pipeline, mutex guards all of its fields
+type pipeline struct {
+	mutex     sync.Mutex
+	state     *storage.Pipeline
+	steps     []*pipelineStep
+	cancelled bool
+}
+
+// pipelineStep holds the job created for a step, which is submitted once the steps it depends on completed
+type pipelineStep struct {
+	request   storage.ExecuteRequest
+	job       *storage.Job
+	stdin     *stdinSource
+	dependsOn []int
+}
+
+// ExecutePipeline validates all steps of the pipeline and starts running it, the steps are submitted as jobs
+// once the steps they depend on completed
+func (e *Executor) ExecutePipeline(req *storage.PipelineRequest) (*storage.Pipeline, error) {
+	dependencies, err := pipelineDependencies(req.Steps)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.config.MaxQueuedJobs > 0 && e.queue.ready() >= e.config.MaxQueuedJobs {
+		return nil, fmt.Errorf("%w: %d jobs are waiting", ErrQueueFull, e.config.MaxQueuedJobs)
+	}
+
+	p := &pipeline{state: &storage.Pipeline{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Tags:        req.Tags,
+		SubmittedBy: req.SubmittedBy,
+		Status:      storage.StatusRunning,
+		CreatedAt:   time.Now(),
+		Steps:       make([]storage.PipelineStepResult, len(req.Steps)),
+	}}
+	for i, step := range req.Steps {
+		request := step.ExecuteRequest
+		request.SubmittedBy = req.SubmittedBy
+		if request.RequestID != "" {
+			return nil, fmt.Errorf("%w: step %q: request_id is not supported in pipelines", ErrInvalidRequest, stepName(step, i))
+		}
+
+		job, stdin, err := e.newJob(&request)
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", stepName(step, i), err)
+		}
+		job.PipelineID = p.state.ID
+		p.steps = append(p.steps, &pipelineStep{request: request, job: job, stdin: stdin, dependsOn: dependencies[i]})
+
+		dependsOn := make([]string, 0, len(dependencies[i]))
+		for _, dependency := range dependencies[i] {
+			dependsOn = append(dependsOn, stepName(req.Steps[dependency], dependency))
+		}
+		p.state.Steps[i] = storage.PipelineStepResult{
+			Name:              stepName(step, i),
+			DependsOn:         dependsOn,
+			ContinueOnFailure: step.ContinueOnFailure,
+			Status:            storage.StepPending,
+		}
+	}
+
+	e.mutex.Lock()
+	e.pipelines[p.state.ID] = p
+	e.mutex.Unlock()
+
+	slog.Info("Pipeline started", "pipeline_id", p.state.ID[:8], "name", p.state.Name, "steps", len(p.steps))
+
+	finished := make(chan int, len(p.steps))
+	p.mutex.Lock()
+	running := e.startSteps(p, finished)
+	p.mutex.Unlock()
+	go e.runPipeline(p, finished, running)
+
+	return e.GetPipeline(p.state.ID)
+}
+
+// pipelineDependencies returns the indices of the steps every step depends on, rejecting unknown and cyclic
+// dependencies
+func pipelineDependencies(steps []storage.PipelineStep) ([][]int, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%w: a pipeline needs at least one step", ErrInvalidRequest)
+	}
+	if len(steps) > maxPipelineSteps {
+		return nil, fmt.Errorf("%w: a pipeline has at most %d steps", ErrInvalidRequest, maxPipelineSteps)
+	}
+
+	indices := make(map[string]int, len(steps))
+	sequential := true
+	for i, step := range steps {
+		name := stepName(step, i)
+		if _, exists := indices[name]; exists {
+			return nil, fmt.Errorf("%w: step name %q is used more than once", ErrInvalidRequest, name)
+		}
+		indices[name] = i
+		if len(step.DependsOn) > 0 {
+			sequential = false
+		}
+	}
+
+	dependencies := make([][]int, len(steps))
+	for i, step := range steps {
+		if sequential {
+			if i > 0 {
+				dependencies[i] = []int{i - 1}
+			}
+			continue
+		}
+		for _, name := range step.DependsOn {
+			dependency, exists := indices[name]
+			if !exists {
+				return nil, fmt.Errorf("%w: step %q depends on unknown step %q", ErrInvalidRequest, stepName(step, i), name)
+			}
+			if dependency == i {
+				return nil, fmt.Errorf("%w: step %q depends on itself", ErrInvalidRequest, name)
+			}
+			dependencies[i] = append(dependencies[i], dependency)
+		}
+	}
+
+	if i := dependencyCycle(dependencies); i >= 0 {
+		return nil, fmt.Errorf("%w: the dependencies of step %q form a cycle", ErrInvalidRequest, stepName(steps[i], i))
+	}
+	return dependencies, nil
+}
+
+// dependencyCycle returns the index of a step from which a dependency cycle is reachable, or -1 if there is none
+func dependencyCycle(dependencies [][]int) int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(dependencies))
+
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[i] = visiting
+		for _, dependency := range dependencies[i] {
+			if visit(dependency) {
+				return true
+			}
+		}
+		state[i] = visited
+		return false
+	}
+
+	for i := range dependencies {
+		if visit(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func stepName(step storage.PipelineStep, i int) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return fmt.Sprintf("step-%d", i+1)
+}
+
+// startSteps submits the pending steps whose dependencies completed, skips those with a dependency which did not
+// and returns the number of submitted steps. p.mutex must be held by the caller.
+func (e *Executor) startSteps(p *pipeline, finished chan<- int) int {
+	started := 0
+	for changed := true; changed; {
+		changed = false
+		for i, step := range p.steps {
+			result := &p.state.Steps[i]
+			if result.Status != storage.StepPending {
+				continue
+			}
+
+			ready, blocked := true, false
+			for _, dependency := range step.dependsOn {
+				dependencyResult := p.state.Steps[dependency]
+				switch {
+				case dependencyResult.Status == storage.StatusCompleted:
+				case dependencyResult.Status == storage.StepSkipped:
+					blocked = true
+				case dependencyResult.Status.IsTerminal():
+					blocked = blocked || !dependencyResult.ContinueOnFailure
+				default:
+					ready = false
+				}
+			}
+
+			switch {
+			case blocked:
+				result.Status = storage.StepSkipped
+				step.stdin = nil
+			case ready:
+				if e.startStep(p, i, finished) {
+					started++
+				}
+			default:
+				continue
+			}
+			changed = true
+		}
+	}
+	return started
+}
+
+// startStep submits the job of the step and reports whether it was submitted, finished receives the index of the
+// step once its job finished. p.mutex must be held by the caller.
+func (e *Executor) startStep(p *pipeline, i int, finished chan<- int) bool {
+	step, result := p.steps[i], &p.state.Steps[i]
+
+	// the job waits in the queue from now on
+	step.job.CreatedAt = time.Now()
+	err := e.submit(step.job, &step.request, step.stdin)
+	step.stdin = nil
+	if err != nil {
+		result.Status = storage.StatusFailed
+		result.Error = err.Error()
+		return false
+	}
+	result.JobID = step.job.ID
+	result.Status = storage.StatusQueued
+
+	e.mutex.RLock()
+	done, exists := e.done[step.job.ID]
+	e.mutex.RUnlock()
+
+	go func() {
+		// jobs without a channel have already finished
+		if exists {
+			select {
+			case <-done:
+			case <-e.closing:
+				return
+			}
+		}
+		finished <- i
+	}()
+	return true
+}
+
+// runPipeline records the steps of the pipeline as their jobs finish and submits the steps depending on them,
+// until no step is running anymore
+func (e *Executor) runPipeline(p *pipeline, finished chan int, running int) {
+	for running > 0 {
+		select {
+		case i := <-finished:
+			running--
+			p.mutex.Lock()
+			if job, exists := e.storage.Get(p.state.Steps[i].JobID); exists {
+				setStepResult(&p.state.Steps[i], job)
+			}
+			if !p.cancelled {
+				running += e.startSteps(p, finished)
+			}
+			p.mutex.Unlock()
+		case <-e.closing:
+			p.mutex.Lock()
+			p.cancelled = true
+			e.finishPipeline(p)
+			p.mutex.Unlock()
+			return
+		}
+	}
+
+	p.mutex.Lock()
+	e.finishPipeline(p)
+	p.mutex.Unlock()
+}
+
+// finishPipeline sets the final status of the pipeline, p.mutex must be held by the caller
+func (e *Executor) finishPipeline(p *pipeline) {
+	status := storage.StatusCompleted
+	for i := range p.state.Steps {
+		result := &p.state.Steps[i]
+		if result.Status == storage.StepPending {
+			result.Status = storage.StatusCancelled
+			p.steps[i].stdin = nil
+		}
+		if (result.Status == storage.StatusFailed || result.Status == storage.StatusCancelled) && !result.ContinueOnFailure {
+			status = storage.StatusFailed
+		}
+	}
+	if p.cancelled {
+		status = storage.StatusCancelled
+	}
+
+	now := time.Now()
+	p.state.Status = status
+	p.state.CompletedAt = &now
+	p.state.DurationMs = now.Sub(p.state.CreatedAt).Milliseconds()
+
+	slog.Info("Pipeline finished",
+		"pipeline_id", p.state.ID[:8],
+		"name", p.state.Name,
+		"status", status,
+		"duration_ms", p.state.DurationMs)
+}
+
+// setStepResult copies the state of the job of a step to its result
+func setStepResult(result *storage.PipelineStepResult, job *storage.Job) {
+	result.Status = job.Status
+	result.ExitCode = job.ExitCode
+	result.Error = job.Error
+	result.StartedAt = job.StartedAt
+	result.CompletedAt = job.CompletedAt
+	result.DurationMs = job.DurationMs
+}
+
+// GetPipeline returns the pipeline with the current state of its steps
+func (e *Executor) GetPipeline(id string) (*storage.Pipeline, error) {
+	e.mutex.RLock()
+	p, exists := e.pipelines[id]
+	e.mutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("pipeline not found")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	snapshot := *p.state
+	snapshot.Steps = slices.Clone(p.state.Steps)
+	for i := range snapshot.Steps {
+		result := &snapshot.Steps[i]
+		if result.JobID != "" && !result.Status.IsTerminal() {
+			if job, exists := e.storage.Get(result.JobID); exists {
+				setStepResult(result, job)
+			}
+		}
+	}
+	return &snapshot, nil
+}
+
+// CancelPipeline cancels the unfinished steps of the pipeline, steps which did not start yet are never submitted
+func (e *Executor) CancelPipeline(id string) error {
+	e.mutex.RLock()
+	p, exists := e.pipelines[id]
+	e.mutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("pipeline not found")
+	}
+
+	p.mutex.Lock()
+	if p.cancelled || p.state.Status.IsTerminal() {
+		status := p.state.Status
+		if p.cancelled {
+			status = storage.StatusCancelled
+		}
+		p.mutex.Unlock()
+		return fmt.Errorf("pipeline cannot be cancelled (status: %s)", status)
+	}
+	p.cancelled = true
+
+	var jobs []string
+	for i := range p.state.Steps {
+		result := &p.state.Steps[i]
+		switch {
+		case result.Status == storage.StepPending:
+			result.Status = storage.StatusCancelled
+			p.steps[i].stdin = nil
+		case result.JobID != "" && !result.Status.IsTerminal():
+			jobs = append(jobs, result.JobID)
+		}
+	}
+	p.mutex.Unlock()
+
+	for _, jobID := range jobs {
+		// the job may have finished meanwhile
+		_ = e.CancelJob(jobID)
+	}
+	return nil
+}
+
+// prunePipelines forgets the finished pipelines whose jobs were all pruned and returns their number
+func (e *Executor) prunePipelines() int {
+	e.mutex.RLock()
+	pipelines := make([]*pipeline, 0, len(e.pipelines))
+	for _, p := range e.pipelines {
+		pipelines = append(pipelines, p)
+	}
+	e.mutex.RUnlock()
+
+	var pruned []string
+	for _, p := range pipelines {
+		p.mutex.Lock()
+		if p.state.Status.IsTerminal() && !slices.ContainsFunc(p.state.Steps, func(result storage.PipelineStepResult) bool {
+			if result.JobID == "" {
+				return false
+			}
+			_, exists := e.storage.Get(result.JobID)
+			return exists
+		}) {
+			pruned = append(pruned, p.state.ID)
+		}
+		p.mutex.Unlock()
+	}
+
+	e.mutex.Lock()
+	for _, id := range pruned {
+		delete(e.pipelines, id)
+	}
+	e.mutex.Unlock()
+	return len(pruned)
+}
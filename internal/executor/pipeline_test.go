@@ -0,0 +1,144 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// waitPipeline waits until the pipeline finished and returns it
+func waitPipeline(t *testing.T, e *Executor, id string) *storage.Pipeline {
+	t.Helper()
+
+	var pipeline *storage.Pipeline
+	require.Eventually(t, func() bool {
+		var err error
+		pipeline, err = e.GetPipeline(id)
+		require.NoError(t, err)
+		return pipeline.Status.IsTerminal()
+	}, 10*time.Second, 10*time.Millisecond)
+	return pipeline
+}
+
+func step(name, command string, dependsOn ...string) storage.PipelineStep {
+	return storage.PipelineStep{
+		Name:           name,
+		DependsOn:      dependsOn,
+		ExecuteRequest: storage.ExecuteRequest{Command: "sh", Args: []string{"-c", command}},
+	}
+}
+
+func TestPipelineSequential(t *testing.T) {
+	e := newTestExecutor(t, 4)
+	marker := t.TempDir() + "/installed"
+
+	pipeline, err := e.ExecutePipeline(&storage.PipelineRequest{
+		Name:        "setup",
+		SubmittedBy: "sct-runner",
+		Steps: []storage.PipelineStep{
+			step("install", "sleep 0.1; touch "+marker),
+			step("verify", "test -f "+marker),
+			{ExecuteRequest: storage.ExecuteRequest{Command: "true"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusRunning, pipeline.Status)
+	assert.NotEmpty(t, pipeline.Steps[0].JobID)
+	assert.Equal(t, storage.StepPending, pipeline.Steps[1].Status, "steps without depends_on run one after another")
+	assert.Equal(t, []string{"install"}, pipeline.Steps[1].DependsOn)
+	assert.Equal(t, "step-3", pipeline.Steps[2].Name)
+
+	pipeline = waitPipeline(t, e, pipeline.ID)
+	assert.Equal(t, storage.StatusCompleted, pipeline.Status)
+	for _, result := range pipeline.Steps {
+		assert.Equal(t, storage.StatusCompleted, result.Status, result.Name)
+		assert.Equal(t, 0, *result.ExitCode)
+	}
+
+	job, err := e.GetJob(pipeline.Steps[1].JobID)
+	require.NoError(t, err)
+	assert.Equal(t, pipeline.ID, job.PipelineID)
+	assert.Equal(t, "sct-runner", job.SubmittedBy)
+}
+
+func TestPipelineFailure(t *testing.T) {
+	e := newTestExecutor(t, 4)
+
+	lenient := step("configure", "exit 2", "install")
+	lenient.ContinueOnFailure = true
+	pipeline, err := e.ExecutePipeline(&storage.PipelineRequest{Steps: []storage.PipelineStep{
+		step("install", "true"),
+		lenient,
+		step("start", "true", "configure"),
+		step("check", "exit 1", "install"),
+		step("verify", "true", "start", "check"),
+		step("report", "true", "verify"),
+	}})
+	require.NoError(t, err)
+
+	pipeline = waitPipeline(t, e, pipeline.ID)
+	assert.Equal(t, storage.StatusFailed, pipeline.Status)
+	statuses := make(map[string]storage.JobStatus)
+	for _, result := range pipeline.Steps {
+		statuses[result.Name] = result.Status
+	}
+	assert.Equal(t, map[string]storage.JobStatus{
+		"install":   storage.StatusCompleted,
+		"configure": storage.StatusFailed,
+		"start":     storage.StatusCompleted,
+		"check":     storage.StatusFailed,
+		"verify":    storage.StepSkipped,
+		"report":    storage.StepSkipped,
+	}, statuses)
+	assert.Equal(t, 2, *pipeline.Steps[1].ExitCode)
+	assert.Empty(t, pipeline.Steps[4].JobID, "skipped steps are never submitted")
+}
+
+func TestPipelineCancel(t *testing.T) {
+	e := newTestExecutor(t, 2)
+
+	pipeline, err := e.ExecutePipeline(&storage.PipelineRequest{Steps: []storage.PipelineStep{
+		step("wait", "sleep 30"),
+		step("next", "true"),
+	}})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		current, err := e.GetPipeline(pipeline.ID)
+		return err == nil && current.Steps[0].Status == storage.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, e.CancelPipeline(pipeline.ID))
+	assert.Error(t, e.CancelPipeline(pipeline.ID), "the pipeline is already cancelled")
+
+	pipeline = waitPipeline(t, e, pipeline.ID)
+	assert.Equal(t, storage.StatusCancelled, pipeline.Status)
+	assert.Equal(t, storage.StatusCancelled, pipeline.Steps[0].Status)
+	assert.Equal(t, storage.StatusCancelled, pipeline.Steps[1].Status)
+	assert.Empty(t, pipeline.Steps[1].JobID)
+
+	_, err = e.GetPipeline("unknown")
+	assert.Error(t, err)
+}
+
+func TestPipelineValidation(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	invalidStep := step("b", "true", "a")
+	invalidStep.Priority = "urgent"
+	for name, steps := range map[string][]storage.PipelineStep{
+		"no steps":        nil,
+		"duplicate names": {step("a", "true"), step("a", "true")},
+		"unknown step":    {step("a", "true", "missing")},
+		"self dependency": {step("a", "true", "a")},
+		"cycle":           {step("a", "true"), step("b", "true", "a", "d"), step("c", "true", "b"), step("d", "true", "c")},
+		"invalid step":    {step("a", "true"), invalidStep},
+	} {
+		_, err := e.ExecutePipeline(&storage.PipelineRequest{Steps: steps})
+		assert.ErrorIs(t, err, ErrInvalidRequest, name)
+	}
+	assert.Zero(t, e.storage.Count(), "no step of an invalid pipeline is submitted")
+}
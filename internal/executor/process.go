@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// ParseSignal returns the signal with the given name, e.g. "SIGTERM"
+func ParseSignal(name string) (syscall.Signal, error) {
+	if sig, exists := signals[name]; exists {
+		return sig, nil
+	}
+
+	names := make([]string, 0, len(signals))
+	for n := range signals {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unsupported signal %q, expected one of: %v", name, names)
+}
+
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+// processGroup runs the command in its own process group, so that the whole process tree can be
+// terminated. When the command context is done, killSignal is sent to the group, followed by
+// SIGKILL if the group is still alive after gracePeriod.
+type processGroup struct {
+	cmd         *exec.Cmd
+	killSignal  syscall.Signal
+	gracePeriod time.Duration
+
+	mutex      sync.Mutex
+	lastSignal syscall.Signal
+	escalation *time.Timer
+	// escalated is closed once SIGKILL was sent to the group after the grace period
+	escalated chan struct{}
+}
+
+// groupPollInterval is how often stop checks whether the processes of a terminated group are gone
+const groupPollInterval = 50 * time.Millisecond
+
+func newProcessGroup(cmd *exec.Cmd, killSignal syscall.Signal, gracePeriod time.Duration) *processGroup {
+	g := &processGroup{
+		cmd:         cmd,
+		killSignal:  killSignal,
+		gracePeriod: gracePeriod,
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = g.terminate
+
+	return g
+}
+
+// terminate is called by exec.Cmd when the command context is done
+func (g *processGroup) terminate() error {
+	if err := g.signal(g.killSignal); err != nil {
+		return err
+	}
+	if g.killSignal == syscall.SIGKILL {
+		return nil
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	escalated := make(chan struct{})
+	g.escalated = escalated
+	g.escalation = time.AfterFunc(g.gracePeriod, func() {
+		defer close(escalated)
+		if err := g.signal(syscall.SIGKILL); err == nil {
+			slog.Warn("Process group did not terminate within grace period, killed",
+				"pid", g.cmd.Process.Pid,
+				"grace_period", g.gracePeriod)
+		}
+	})
+	return nil
+}
+
+func (g *processGroup) signal(sig syscall.Signal) error {
+	if err := syscall.Kill(-g.cmd.Process.Pid, sig); err != nil {
+		if err == syscall.ESRCH {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+
+	g.mutex.Lock()
+	g.lastSignal = sig
+	g.mutex.Unlock()
+	return nil
+}
+
+// stop is called once the command exited. If the group was terminated, it waits until no process of the group
+// is left or the SIGKILL escalation was sent: members ignoring killSignal may outlive the leader and must not
+// survive the job.
+func (g *processGroup) stop() {
+	g.mutex.Lock()
+	escalation, escalated := g.escalation, g.escalated
+	g.mutex.Unlock()
+	if escalation == nil {
+		return
+	}
+
+	ticker := time.NewTicker(groupPollInterval)
+	defer ticker.Stop()
+	for g.alive() {
+		select {
+		case <-escalated:
+			return
+		case <-ticker.C:
+		}
+	}
+	escalation.Stop()
+}
+
+// alive reports whether any process of the group still exists, zombies included
+func (g *processGroup) alive() bool {
+	return syscall.Kill(-g.cmd.Process.Pid, 0) != syscall.ESRCH
+}
+
+// terminationSignal returns the name of the signal which terminated the job: the last signal sent
+// by the agent or, if none was sent, the signal which killed the process, if any.
+func (g *processGroup) terminationSignal() string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.lastSignal != 0 {
+		return signalName(g.lastSignal)
+	}
+	if g.cmd.ProcessState == nil {
+		return ""
+	}
+	if status, ok := g.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return signalName(status.Signal())
+	}
+	return ""
+}
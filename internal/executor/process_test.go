@@ -0,0 +1,97 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// processGone reports whether the process exited, orphans which nobody reaps are left as zombies
+func processGone(t *testing.T, pid int) bool {
+	t.Helper()
+
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if os.IsNotExist(err) {
+		return true
+	}
+	require.NoError(t, err)
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return fields[0] == "Z"
+}
+
+func TestTerminateKillsGroupMembers(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	// the background process ignores SIGTERM and does not hold the output pipes, so the job ends with its leader
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command:     "sh",
+		Args:        []string{"-c", `(trap "" TERM; exec sleep 4242) >/dev/null 2>&1 & echo $!; sleep 100`},
+		GracePeriod: 1,
+	})
+	require.NoError(t, err)
+
+	var pid int
+	require.Eventually(t, func() bool {
+		data, _, err := e.ReadOutput(job.ID, StreamStdout, 0, 64)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, e.CancelJob(job.ID))
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCancelled, finished.Status)
+	assert.Equal(t, "SIGKILL", finished.TerminationSignal, "the group member ignoring SIGTERM is killed")
+	assert.Eventually(t, func() bool { return processGone(t, pid) }, time.Second, 10*time.Millisecond,
+		"the group member does not survive the job")
+}
+
+func TestTerminateEscalation(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	// the leader and its child ignore SIGTERM
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command:     "sh",
+		Args:        []string{"-c", `trap "" TERM; sleep 100`},
+		Timeout:     1,
+		GracePeriod: 1,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusFailed, finished.Status)
+	assert.Equal(t, storage.FailureTimeout, finished.FailureReason)
+	assert.Equal(t, "SIGKILL", finished.TerminationSignal)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestTerminateWithoutEscalation(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "sleep", Args: []string{"100"}, GracePeriod: 30})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		current, err := e.GetJob(job.ID)
+		return err == nil && current.Status == storage.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, e.CancelJob(job.ID))
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SIGTERM", finished.TerminationSignal)
+	assert.Less(t, time.Since(start), 5*time.Second, "the grace period is not waited for once the group is gone")
+}
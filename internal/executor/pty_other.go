@@ -0,0 +1,18 @@
+//go:build !linux
+
+package executor
+
+import (
+	"errors"
+	"os"
+)
+
+var errPTYUnsupported = errors.New("interactive sessions are only supported on Linux")
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errPTYUnsupported
+}
+
+func resizePTY(master *os.File, rows, cols uint16) error {
+	return errPTYUnsupported
+}
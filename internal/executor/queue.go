@@ -0,0 +1,237 @@
+package executor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// priorities lists the priority classes from the most to the least important one
+var priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+func validPriority(priority string) bool {
+	for _, p := range priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+type queuedJob struct {
+	job *storage.Job
+	// queuedAt is when the job became ready, jobs queued with a delay are not served before it
+	queuedAt time.Time
+}
+
+// jobQueue orders jobs waiting for a worker by priority, FIFO within a priority class.
+//
+// To prevent starvation, a job which has waited for longer than starvationTimeout is served before
+// jobs of higher priority classes which have not waited that long. Jobs queued with pushAt are skipped
+// until their time comes.
+type jobQueue struct {
+	mutex             sync.Mutex
+	cond              *sync.Cond
+	classes           map[string][]*queuedJob
+	starvationTimeout time.Duration
+	closed            bool
+}
+
+func newJobQueue(starvationTimeout time.Duration) *jobQueue {
+	q := &jobQueue{
+		classes:           make(map[string][]*queuedJob),
+		starvationTimeout: starvationTimeout,
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *jobQueue) push(job *storage.Job) {
+	q.pushAt(job, time.Now())
+}
+
+// pushAt queues the job, which is not served before at
+func (q *jobQueue) pushAt(job *storage.Job, at time.Time) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.classes[job.Priority] = append(q.classes[job.Priority], &queuedJob{job: job, queuedAt: at})
+	q.cond.Signal()
+}
+
+// pop blocks until a job is ready and returns it, or returns nil once the queue is closed
+func (q *jobQueue) pop() *storage.Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for {
+		if q.closed {
+			return nil
+		}
+		now := time.Now()
+		if priority, i := q.next(now); priority != "" {
+			item := q.classes[priority][i]
+			q.classes[priority] = append(q.classes[priority][:i:i], q.classes[priority][i+1:]...)
+			return item.job
+		}
+
+		// wake up once the earliest delayed job is ready
+		var timer *time.Timer
+		if at, delayed := q.earliest(); delayed {
+			timer = time.AfterFunc(at.Sub(now), q.cond.Broadcast)
+		}
+		q.cond.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next returns the priority class to be served next together with the index of its first ready job, or an
+// empty string if no job is ready
+func (q *jobQueue) next(now time.Time) (string, int) {
+	heads := make(map[string]int, len(priorities))
+	for _, priority := range priorities {
+		for i, item := range q.classes[priority] {
+			if !item.queuedAt.After(now) {
+				heads[priority] = i
+				break
+			}
+		}
+	}
+
+	starving := ""
+	var starvingSince time.Time
+	for _, priority := range priorities {
+		i, ready := heads[priority]
+		if !ready {
+			continue
+		}
+		head := q.classes[priority][i]
+		if q.starvationTimeout > 0 && now.Sub(head.queuedAt) >= q.starvationTimeout &&
+			(starving == "" || head.queuedAt.Before(starvingSince)) {
+			starving = priority
+			starvingSince = head.queuedAt
+		}
+	}
+	if starving != "" {
+		return starving, heads[starving]
+	}
+
+	for _, priority := range priorities {
+		if i, ready := heads[priority]; ready {
+			return priority, i
+		}
+	}
+	return "", 0
+}
+
+// earliest returns the time the earliest delayed job becomes ready, if there is any
+func (q *jobQueue) earliest() (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for _, items := range q.classes {
+		for _, item := range items {
+			if !found || item.queuedAt.Before(earliest) {
+				earliest = item.queuedAt
+				found = true
+			}
+		}
+	}
+	return earliest, found
+}
+
+// remove takes the job out of the queue, returning false if it is not queued
+func (q *jobQueue) remove(id string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for priority, items := range q.classes {
+		for i, item := range items {
+			if item.job.ID == id {
+				q.classes[priority] = append(items[:i:i], items[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// position returns the 1-based position of the job in the queue or 0 if it is not queued.
+// Starving jobs are not taken into account, so the position is an estimate.
+func (q *jobQueue) position(id string) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	ahead := 0
+	for _, priority := range priorities {
+		for i, item := range q.classes[priority] {
+			if item.job.ID == id {
+				return ahead + i + 1
+			}
+		}
+		ahead += len(q.classes[priority])
+	}
+	return 0
+}
+
+func (q *jobQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	count := 0
+	for _, items := range q.classes {
+		count += len(items)
+	}
+	return count
+}
+
+// ready returns the number of jobs which can be served now, jobs queued with a delay are not counted
+func (q *jobQueue) ready() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, items := range q.classes {
+		for _, item := range items {
+			if !item.queuedAt.After(now) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// delayed returns the IDs of the jobs queued with a delay which has not passed yet
+func (q *jobQueue) delayed() []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	var ids []string
+	for _, items := range q.classes {
+		for _, item := range items {
+			if item.queuedAt.After(now) {
+				ids = append(ids, item.job.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// close wakes up all workers waiting in pop and makes them return
+func (q *jobQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestJobQueuePriorities(t *testing.T) {
+	queue := newJobQueue(0)
+
+	for _, job := range []*storage.Job{
+		{ID: "low-1", Priority: PriorityLow},
+		{ID: "normal-1", Priority: PriorityNormal},
+		{ID: "high-1", Priority: PriorityHigh},
+		{ID: "normal-2", Priority: PriorityNormal},
+		{ID: "high-2", Priority: PriorityHigh},
+	} {
+		queue.push(job)
+	}
+
+	assert.Equal(t, 5, queue.len())
+	assert.Equal(t, 1, queue.position("high-1"))
+	assert.Equal(t, 4, queue.position("normal-2"))
+	assert.Equal(t, 5, queue.position("low-1"))
+	assert.Equal(t, 0, queue.position("unknown"))
+
+	var order []string
+	for queue.len() > 0 {
+		order = append(order, queue.pop().ID)
+	}
+	assert.Equal(t, []string{"high-1", "high-2", "normal-1", "normal-2", "low-1"}, order)
+}
+
+func TestJobQueueStarvation(t *testing.T) {
+	queue := newJobQueue(time.Minute)
+
+	queue.push(&storage.Job{ID: "low", Priority: PriorityLow})
+	queue.push(&storage.Job{ID: "high", Priority: PriorityHigh})
+
+	// pretend the low priority job has been waiting for too long
+	queue.classes[PriorityLow][0].queuedAt = time.Now().Add(-2 * time.Minute)
+
+	assert.Equal(t, "low", queue.pop().ID)
+	assert.Equal(t, "high", queue.pop().ID)
+}
+
+func TestJobQueueDelay(t *testing.T) {
+	queue := newJobQueue(0)
+
+	queue.pushAt(&storage.Job{ID: "delayed", Priority: PriorityHigh}, time.Now().Add(200*time.Millisecond))
+	queue.push(&storage.Job{ID: "ready", Priority: PriorityLow})
+
+	assert.Equal(t, "ready", queue.pop().ID, "delayed jobs are skipped until they are due")
+
+	start := time.Now()
+	assert.Equal(t, "delayed", queue.pop().ID)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestJobQueueRemoveAndClose(t *testing.T) {
+	queue := newJobQueue(0)
+
+	queue.push(&storage.Job{ID: "job-1", Priority: PriorityNormal})
+	queue.push(&storage.Job{ID: "job-2", Priority: PriorityNormal})
+
+	assert.True(t, queue.remove("job-1"))
+	assert.False(t, queue.remove("job-1"))
+	assert.Equal(t, 1, queue.position("job-2"))
+	assert.Equal(t, "job-2", queue.pop().ID)
+
+	popped := make(chan *storage.Job)
+	go func() {
+		popped <- queue.pop()
+	}()
+
+	queue.close()
+	select {
+	case job := <-popped:
+		require.Nil(t, job)
+	case <-time.After(time.Second):
+		t.Fatal("pop did not return after the queue was closed")
+	}
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cleanup prunes the finished jobs selected by the retention policy, removes the output spilled to disk and the
+// finished pipelines of jobs which no longer exist, forgets the request IDs submitted before the idempotency
+// window and returns the number of pruned jobs
+func (e *Executor) Cleanup() int {
+	pruned := e.storage.Cleanup(e.config.Retention)
+	e.config.Metrics.JobsPruned(pruned)
+
+	removed := e.removeOrphanedOutput()
+	pipelines := e.prunePipelines()
+	requests := e.pruneRequests()
+	if pruned > 0 || removed > 0 || pipelines > 0 || requests > 0 {
+		slog.Info("Pruned finished jobs", "jobs", pruned, "output_files", removed, "pipelines", pipelines, "request_ids", requests, "remaining_jobs", e.storage.Count())
+	}
+	return pruned
+}
+
+// WatchRetention runs Cleanup right away and then every interval until the context is done
+func (e *Executor) WatchRetention(ctx context.Context, interval time.Duration) {
+	e.Cleanup()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Cleanup()
+		}
+	}
+}
+
+// removeOrphanedOutput removes spilled output files of jobs which are no longer stored and returns their number.
+// Jobs are saved before their output is created, so the files of live jobs are never removed.
+func (e *Executor) removeOrphanedOutput() int {
+	if e.config.OutputDir == "" {
+		return 0
+	}
+	entries, err := os.ReadDir(e.config.OutputDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read output directory", "output_dir", e.config.OutputDir, "error", err)
+		}
+		return 0
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		id, stream, ok := strings.Cut(entry.Name(), ".")
+		if !ok || entry.IsDir() || (stream != StreamStdout && stream != StreamStderr) {
+			continue
+		}
+		if _, exists := e.storage.Get(id); exists {
+			continue
+		}
+		if err := os.Remove(filepath.Join(e.config.OutputDir, entry.Name())); err != nil {
+			slog.Warn("Failed to remove output file", "path", entry.Name(), "error", err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
@@ -0,0 +1,173 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	// maxRetryAttempts limits the attempts of a retry policy
+	maxRetryAttempts = 100
+	// defaultRetryBackoff is the delay before the first retry of a policy without backoff_seconds
+	defaultRetryBackoff = time.Second
+	// maxRetryDelay caps the delay between attempts, also when the policy sets no or a larger max_backoff_seconds
+	maxRetryDelay = 24 * time.Hour
+)
+
+// validateRetryPolicy checks the retry policy of a request, nil is valid
+func validateRetryPolicy(policy *storage.RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MaxAttempts < 1 || policy.MaxAttempts > maxRetryAttempts {
+		return fmt.Errorf("%w: retry max_attempts must be between 1 and %d", ErrInvalidRequest, maxRetryAttempts)
+	}
+	if policy.BackoffSeconds < 0 || policy.MaxBackoffSeconds < 0 {
+		return fmt.Errorf("%w: retry backoff must not be negative", ErrInvalidRequest)
+	}
+	if policy.BackoffMultiplier != 0 && policy.BackoffMultiplier < 1 {
+		return fmt.Errorf("%w: retry backoff_multiplier must be at least 1", ErrInvalidRequest)
+	}
+	if _, err := regexp.Compile(policy.OnStderr); err != nil {
+		return fmt.Errorf("%w: invalid retry on_stderr: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// runAttempt runs the next attempt of the job, limited by ctx. If the retry policy of the job retries the attempt,
+// the job is set back to queued and the time its next attempt is due is returned.
+func (e *Executor) runAttempt(ctx context.Context, job *storage.Job) (time.Time, bool) {
+	job.Attempt++
+	if job.Attempt > 1 {
+		e.resetOutput(job)
+	}
+	attempt := storage.JobAttempt{Attempt: job.Attempt, StartedAt: time.Now()}
+	if job.AttemptStartedAt != nil {
+		attempt.StartedAt = *job.AttemptStartedAt
+	}
+
+	e.runCommand(ctx, job)
+
+	if job.Retry == nil {
+		return time.Time{}, false
+	}
+	recordAttempt(job, &attempt)
+	if !shouldRetry(job, attempt.Stderr) {
+		return time.Time{}, false
+	}
+
+	delay := retryDelay(job.Retry, job.Attempt)
+	exitCode := -1
+	if attempt.ExitCode != nil {
+		exitCode = *attempt.ExitCode
+	}
+	slog.Info("Retrying job",
+		"job_id", job.ID[:8],
+		"attempt", job.Attempt,
+		"exit_code", exitCode,
+		"failure_reason", attempt.FailureReason,
+		"delay", delay)
+
+	retryAt := time.Now().Add(delay)
+	e.mutex.Lock()
+	job.Status = storage.StatusQueued
+	job.NextAttemptAt = &retryAt
+	job.ExitCode = nil
+	job.TerminationSignal = ""
+	job.Error = ""
+	job.FailureReason = ""
+	job.Stdout, job.Stderr = "", ""
+	job.StdoutBytes, job.StderrBytes = 0, 0
+	job.OutputTruncated = false
+	e.mutex.Unlock()
+	e.storage.Save(job)
+
+	return retryAt, true
+}
+
+// recordAttempt appends the finished attempt to the attempts of the job
+func recordAttempt(job *storage.Job, attempt *storage.JobAttempt) {
+	attempt.Status = job.Status
+	attempt.CompletedAt = time.Now()
+	attempt.DurationMs = attempt.CompletedAt.Sub(attempt.StartedAt).Milliseconds()
+	attempt.ExitCode = job.ExitCode
+	attempt.TerminationSignal = job.TerminationSignal
+	attempt.Error = job.Error
+	attempt.FailureReason = job.FailureReason
+	attempt.Stdout = job.Stdout
+	attempt.Stderr = job.Stderr
+	attempt.StdoutBytes = job.StdoutBytes
+	attempt.StderrBytes = job.StderrBytes
+	attempt.OutputTruncated = job.OutputTruncated
+	job.Attempts = append(job.Attempts, *attempt)
+}
+
+// resetOutput replaces the output of the job with an empty one for its next attempt. Followers of the previous
+// attempt reach the end of its output, whose spilled full output is removed.
+func (e *Executor) resetOutput(job *storage.Job) {
+	output := newJobOutput(job.OutputLimit, e.config.OutputDir, job.ID)
+
+	e.mutex.Lock()
+	previous, exists := e.outputs[job.ID]
+	e.outputs[job.ID] = output
+	e.mutex.Unlock()
+
+	if exists {
+		previous.close()
+	}
+	for _, stream := range []string{StreamStdout, StreamStderr} {
+		if path := spillPath(e.config.OutputDir, job.ID, stream); path != "" {
+			os.Remove(path)
+		}
+	}
+}
+
+// shouldRetry reports whether the retry policy of the job retries its last attempt, which wrote stderr
+func shouldRetry(job *storage.Job, stderr string) bool {
+	policy := job.Retry
+	if policy == nil || job.Status != storage.StatusFailed || job.Attempt >= policy.MaxAttempts {
+		return false
+	}
+	if len(policy.OnExitCodes) == 0 && policy.OnStderr == "" && !policy.OnTimeout {
+		return true
+	}
+
+	if policy.OnTimeout && job.FailureReason == storage.FailureTimeout {
+		return true
+	}
+	if job.ExitCode != nil && slices.Contains(policy.OnExitCodes, *job.ExitCode) {
+		return true
+	}
+	if policy.OnStderr != "" {
+		if pattern, err := regexp.Compile(policy.OnStderr); err == nil && pattern.MatchString(stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns the backoff after the given failed attempt, at most maxRetryDelay
+func retryDelay(policy *storage.RetryPolicy, attempt int) time.Duration {
+	backoff := policy.BackoffSeconds
+	if backoff == 0 {
+		backoff = defaultRetryBackoff.Seconds()
+	}
+	multiplier := policy.BackoffMultiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	// the exponential growth exceeds the range of time.Duration after a few dozen attempts
+	seconds := min(backoff*math.Pow(multiplier, float64(attempt-1)), maxRetryDelay.Seconds())
+	if policy.MaxBackoffSeconds > 0 {
+		seconds = min(seconds, policy.MaxBackoffSeconds)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
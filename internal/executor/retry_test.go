@@ -0,0 +1,195 @@
+package executor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	counter := filepath.Join(t.TempDir(), "counter")
+
+	// fails twice with exit code 3, then succeeds
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command: "sh",
+		Args:    []string{"-c", `echo x >> "$0"; n=$(wc -l < "$0"); echo "attempt $n"; [ $n -ge 3 ] || { echo mirror down >&2; exit 3; }`, counter},
+		Retry:   &storage.RetryPolicy{MaxAttempts: 5, BackoffSeconds: 0.01, OnExitCodes: []int{3}},
+	})
+	require.NoError(t, err)
+	finished, err := e.WaitJob(context.Background(), job.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, storage.StatusCompleted, finished.Status)
+	assert.Equal(t, 3, finished.Attempt)
+	require.Len(t, finished.Attempts, 3)
+	assert.Equal(t, "attempt 3\n", finished.Stdout, "the output of the job is that of its last attempt")
+	assert.Empty(t, finished.Stderr)
+	assert.Equal(t, int64(10), finished.StdoutBytes)
+
+	first, last := finished.Attempts[0], finished.Attempts[2]
+	assert.Equal(t, storage.StatusFailed, first.Status)
+	assert.Equal(t, 3, *first.ExitCode)
+	assert.Equal(t, "attempt 1\n", first.Stdout)
+	assert.Equal(t, "mirror down\n", first.Stderr)
+	assert.Equal(t, storage.StatusCompleted, last.Status)
+	assert.Equal(t, 0, *last.ExitCode)
+	assert.Equal(t, "attempt 3\n", last.Stdout)
+	assert.Equal(t, int64(10), last.StdoutBytes)
+	assert.False(t, last.StartedAt.Before(first.CompletedAt))
+}
+
+func TestRetryConditions(t *testing.T) {
+	e := newTestExecutor(t, 2)
+
+	run := func(policy *storage.RetryPolicy, script string, timeout int) *storage.Job {
+		t.Helper()
+		job, err := e.Execute(&storage.ExecuteRequest{Command: "sh", Args: []string{"-c", script}, Timeout: timeout, Retry: policy})
+		require.NoError(t, err)
+		finished, err := e.WaitJob(context.Background(), job.ID)
+		require.NoError(t, err)
+		return finished
+	}
+
+	job := run(&storage.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 0.01, OnExitCodes: []int{3}}, "exit 4", 0)
+	assert.Equal(t, storage.StatusFailed, job.Status)
+	assert.Equal(t, 1, job.Attempt, "other exit codes are not retried")
+
+	job = run(&storage.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 0.01, OnStderr: "gossip.*settl"}, "echo waiting for gossip to settle >&2; exit 1", 0)
+	assert.Equal(t, 3, job.Attempt, "all attempts failed with matching stderr")
+	assert.Len(t, job.Attempts, 3)
+
+	job = run(&storage.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 0.01, OnTimeout: true}, "sleep 5", 1)
+	assert.Equal(t, 2, job.Attempt)
+	assert.Equal(t, storage.FailureTimeout, job.FailureReason)
+
+	job = run(&storage.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 0.01}, "exit 1", 0)
+	assert.Equal(t, 2, job.Attempt, "a policy without conditions retries every failure")
+
+	job = run(nil, "exit 1", 0)
+	assert.Equal(t, 1, job.Attempt)
+	assert.Empty(t, job.Attempts, "attempts are recorded for jobs with a retry policy only")
+}
+
+func TestRetryCancel(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command: "false",
+		Retry:   &storage.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 60},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		current, err := e.GetJob(job.ID)
+		return err == nil && len(current.Attempts) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, e.CancelJob(job.ID))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	finished, err := e.WaitJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCancelled, finished.Status, "the backoff is interrupted")
+	assert.Equal(t, 1, finished.Attempt)
+}
+
+func TestRetryBackoffReleasesWorker(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	retried, err := e.Execute(&storage.ExecuteRequest{
+		Command: "false",
+		Retry:   &storage.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 60},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		current, err := e.GetJob(retried.ID)
+		return err == nil && current.Status == storage.StatusQueued && current.NextAttemptAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the only worker is free during the backoff
+	job, err := e.Execute(&storage.ExecuteRequest{Command: "true"})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	finished, err := e.WaitJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCompleted, finished.Status)
+
+	require.NoError(t, e.CancelJob(retried.ID))
+}
+
+func TestRetryValidation(t *testing.T) {
+	e := newTestExecutor(t, 1)
+
+	for name, policy := range map[string]*storage.RetryPolicy{
+		"no attempts":          {},
+		"too many attempts":    {MaxAttempts: 1000},
+		"negative backoff":     {MaxAttempts: 2, BackoffSeconds: -1},
+		"shrinking backoff":    {MaxAttempts: 2, BackoffMultiplier: 0.5},
+		"invalid stderr regex": {MaxAttempts: 2, OnStderr: "("},
+	} {
+		_, err := e.Execute(&storage.ExecuteRequest{Command: "true", Retry: policy})
+		assert.ErrorIs(t, err, ErrInvalidRequest, name)
+	}
+
+	policy := &storage.RetryPolicy{BackoffSeconds: 1, MaxBackoffSeconds: 5}
+	assert.Equal(t, time.Second, retryDelay(policy, 1))
+	assert.Equal(t, 4*time.Second, retryDelay(policy, 3))
+	assert.Equal(t, 5*time.Second, retryDelay(policy, 4))
+
+	policy = &storage.RetryPolicy{MaxAttempts: 100}
+	assert.Equal(t, defaultRetryBackoff, retryDelay(policy, 1), "retries are not immediate without backoff_seconds")
+	assert.Equal(t, 2*defaultRetryBackoff, retryDelay(policy, 2))
+	for _, attempt := range []int{35, 64, 99} {
+		assert.Equal(t, maxRetryDelay, retryDelay(policy, attempt), "the delay of attempt %d does not overflow", attempt)
+	}
+	policy.MaxBackoffSeconds = 1e12
+	assert.Equal(t, maxRetryDelay, retryDelay(policy, 99), "max_backoff_seconds does not lift the upper bound")
+}
+
+func TestRetryBackoffQueueLimit(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	e.config.MaxQueuedJobs = 1
+
+	retried, err := e.Execute(&storage.ExecuteRequest{
+		Command: "false",
+		Retry:   &storage.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 60},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		current, err := e.GetJob(retried.ID)
+		return err == nil && current.NextAttemptAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = e.Execute(&storage.ExecuteRequest{Command: "true"})
+	assert.NoError(t, err, "jobs waiting out their backoff do not fill the queue")
+	require.NoError(t, e.CancelJob(retried.ID))
+}
+
+func TestRetryShutdown(t *testing.T) {
+	e := NewExecutor(Config{MaxConcurrentJobs: 1, OutputDir: t.TempDir()}, storage.NewMemory())
+
+	job, err := e.Execute(&storage.ExecuteRequest{
+		Command: "false",
+		Retry:   &storage.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 60},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		current, err := e.GetJob(job.ID)
+		return err == nil && current.NextAttemptAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, e.Shutdown(ctx))
+	finished, err := e.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCancelled, finished.Status, "pending retries do not stay queued")
+	assert.Equal(t, 1, finished.Attempt)
+}
@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// recordRusage adds the resource usage of the exited command to the resources of the job
+func recordRusage(job *storage.Job, state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+
+	if job.Resources == nil {
+		job.Resources = &storage.JobResources{}
+	}
+	resources := job.Resources
+	resources.UserCPUMs = state.UserTime().Milliseconds()
+	resources.SystemCPUMs = state.SystemTime().Milliseconds()
+	resources.MaxRSSBytes = int64(rusage.Maxrss)
+	// ru_maxrss is in kilobytes everywhere but on macOS
+	if runtime.GOOS != "darwin" {
+		resources.MaxRSSBytes *= 1024
+	}
+	resources.VoluntaryContextSwitches = int64(rusage.Nvcsw)
+	resources.InvoluntaryContextSwitches = int64(rusage.Nivcsw)
+	resources.BlockInputOps = int64(rusage.Inblock)
+	resources.BlockOutputOps = int64(rusage.Oublock)
+}
@@ -0,0 +1,414 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// ErrSessionLimit is returned when the maximum number of concurrent interactive sessions is reached
+var ErrSessionLimit = errors.New("too many interactive sessions")
+
+var (
+	errSessionIdle     = errors.New("session idle timeout")
+	errSessionDetached = errors.New("session closed by the client")
+)
+
+const defaultTerm = "xterm-256color"
+
+// SessionOptions configures the terminal of an interactive session
+type SessionOptions struct {
+	Rows uint16
+	Cols uint16
+	// Term is the TERM environment variable of the command, defaultTerm if empty
+	Term string
+	// IdleTimeout terminates the session when neither input nor output happened for this long,
+	// Config.SessionIdleTimeout if 0
+	IdleTimeout time.Duration
+}
+
+// Session is a command running under a pseudo-terminal. Reads return the terminal output, writes are sent
+// to the terminal input. Sessions are started immediately instead of being queued, their jobs are recorded
+// like any other job with Interactive set and the terminal output kept as stdout.
+type Session struct {
+	executor *Executor
+	job      *storage.Job
+	master   *os.File
+	cgroup   *jobCgroup
+	output   *io.PipeReader
+	cancel   context.CancelCauseFunc
+
+	lastActivity atomic.Int64
+	done         chan struct{}
+}
+
+// StartSession starts the command of the request under a new pseudo-terminal. Stdin, priority, output limit
+// and callback of the request do not apply to sessions, the timeout is only enforced if set.
+func (e *Executor) StartSession(req *storage.ExecuteRequest, opts SessionOptions) (*Session, error) {
+	if opts.IdleTimeout < 0 {
+		return nil, fmt.Errorf("%w: idle timeout must not be negative", ErrInvalidRequest)
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = e.config.SessionIdleTimeout
+	}
+	if opts.Term == "" {
+		opts.Term = defaultTerm
+	}
+
+	killSignalName, gracePeriod, err := e.terminationSettings(req)
+	if err != nil {
+		return nil, err
+	}
+	killSignal, _ := ParseSignal(killSignalName)
+
+	envMode, err := e.envMode(req.EnvMode, req.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mutex.Lock()
+	select {
+	case <-e.closing:
+		e.mutex.Unlock()
+		return nil, fmt.Errorf("agent is shutting down")
+	default:
+	}
+	if e.config.MaxSessions > 0 && e.sessions >= e.config.MaxSessions {
+		e.mutex.Unlock()
+		return nil, fmt.Errorf("%w: limit of %d reached", ErrSessionLimit, e.config.MaxSessions)
+	}
+	e.sessions++
+	e.mutex.Unlock()
+
+	started := false
+	defer func() {
+		if !started {
+			e.mutex.Lock()
+			e.sessions--
+			e.mutex.Unlock()
+		}
+	}()
+
+	job := &storage.Job{
+		ID:          uuid.New().String(),
+		Command:     req.Command,
+		Args:        req.Args,
+		WorkingDir:  req.WorkingDir,
+		Env:         e.MaskEnv(req.Env),
+		EnvMode:     envMode,
+		Timeout:     req.Timeout,
+		Tags:        req.Tags,
+		SubmittedBy: req.SubmittedBy,
+		User:        req.User,
+		Group:       req.Group,
+		Groups:      req.Groups,
+		Limits:      req.Limits,
+		Interactive: true,
+		OutputLimit: e.config.OutputLimitBytes,
+		KillSignal:  killSignalName,
+		GracePeriod: gracePeriod,
+		CreatedAt:   time.Now(),
+	}
+
+	credential, err := jobCredential(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.config.Cgroups.validate(job.Limits); err != nil {
+		return nil, err
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pseudo-terminal: %w", err)
+	}
+	defer slave.Close()
+	if opts.Rows > 0 && opts.Cols > 0 {
+		if err := resizePTY(master, opts.Rows, opts.Cols); err != nil {
+			master.Close()
+			return nil, fmt.Errorf("failed to set terminal size: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	runCtx, stopTimeout := ctx, context.CancelFunc(func() {})
+	if job.Timeout > 0 {
+		runCtx, stopTimeout = context.WithTimeout(ctx, time.Duration(job.Timeout)*time.Second)
+	}
+
+	cmd := exec.CommandContext(runCtx, job.Command, job.Args...)
+	if job.WorkingDir != "" {
+		cmd.Dir = job.WorkingDir
+	}
+	cmd.Env = sessionEnv(e.environment(req.Env, envMode), req.Env, opts.Term)
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+
+	group := newProcessGroup(cmd, killSignal, time.Duration(gracePeriod)*time.Second)
+	// the command becomes a session leader, which also leads its own process group, so setpgid is not
+	// needed and would fail
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Credential = credential
+
+	cgroup, err := e.config.Cgroups.create(job)
+	if err != nil {
+		stopTimeout()
+		cancel(nil)
+		master.Close()
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	releaseCgroup, err := cgroup.attach(cmd)
+	if err == nil {
+		err = cmd.Start()
+		releaseCgroup()
+	}
+	if err != nil {
+		cgroup.remove()
+		stopTimeout()
+		cancel(nil)
+		master.Close()
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+	started = true
+
+	now := time.Now()
+	job.Status = storage.StatusRunning
+	job.StartedAt = &now
+	if err := e.storage.Save(job); err != nil {
+		slog.Error("Failed to save session", "job_id", job.ID[:8], "error", err)
+	}
+
+	output := newJobOutput(job.OutputLimit, e.config.OutputDir, job.ID)
+	e.mutex.Lock()
+	e.outputs[job.ID] = output
+	e.done[job.ID] = make(chan struct{})
+	e.cancelFuncs[job.ID] = func() { cancel(nil) }
+	e.mutex.Unlock()
+
+	reader, writer := io.Pipe()
+	s := &Session{
+		executor: e,
+		job:      job,
+		master:   master,
+		cgroup:   cgroup,
+		output:   reader,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	s.touch()
+
+	e.logJobStart(job)
+
+	var copying sync.WaitGroup
+	copying.Add(1)
+	go func() {
+		defer copying.Done()
+		s.copyOutput(output.stdout, writer)
+	}()
+	go s.watchIdle(opts.IdleTimeout)
+	go func() {
+		defer stopTimeout()
+		s.wait(runCtx, cmd, group, output, writer, &copying)
+	}()
+
+	return s, nil
+}
+
+// sessionEnv returns the environment of a session command, which is built like for regular jobs with TERM set
+// to the terminal type unless env of the request sets it
+func sessionEnv(environment []string, env map[string]string, term string) []string {
+	if _, exists := env["TERM"]; exists {
+		return environment
+	}
+
+	result := make([]string, 0, len(environment)+1)
+	for _, variable := range environment {
+		// the agent terminal, if any, is not the one of the session
+		if !strings.HasPrefix(variable, "TERM=") {
+			result = append(result, variable)
+		}
+	}
+	return append(result, "TERM="+term)
+}
+
+// ID returns the ID of the job recording the session
+func (s *Session) ID() string {
+	return s.job.ID
+}
+
+// Read reads the terminal output, it returns io.EOF once the command has exited
+func (s *Session) Read(p []byte) (int, error) {
+	return s.output.Read(p)
+}
+
+// Write sends input to the terminal
+func (s *Session) Write(p []byte) (int, error) {
+	s.touch()
+	return s.master.Write(p)
+}
+
+// Resize changes the size of the terminal
+func (s *Session) Resize(rows, cols uint16) error {
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("%w: terminal size must not be zero", ErrInvalidRequest)
+	}
+	s.touch()
+	return resizePTY(s.master, rows, cols)
+}
+
+// Done returns a channel closed once the command has exited and the session job is saved
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Job returns the current state of the session job
+func (s *Session) Job() (*storage.Job, error) {
+	return s.executor.GetJob(s.job.ID)
+}
+
+// Close detaches the client, terminating the command if it is still running, and waits for the session to finish
+func (s *Session) Close() {
+	s.cancel(errSessionDetached)
+	// output produced while terminating is still recorded on the job
+	s.output.Close()
+	<-s.done
+}
+
+func (s *Session) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// copyOutput records the terminal output and passes it to the reader of the session until the terminal
+// is closed. Output is kept being recorded when the reader is gone.
+func (s *Session) copyOutput(record io.Writer, reader *io.PipeWriter) {
+	buffer := make([]byte, 32*1024)
+	attached := true
+	for {
+		n, err := s.master.Read(buffer)
+		if n > 0 {
+			s.touch()
+			record.Write(buffer[:n])
+			if attached {
+				if _, err := reader.Write(buffer[:n]); err != nil {
+					attached = false
+				}
+			}
+		}
+		if err != nil {
+			// the terminal reports EIO once the last process holding it has exited
+			return
+		}
+	}
+}
+
+func (s *Session) watchIdle(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, s.lastActivity.Load()))
+			if idle >= timeout {
+				slog.Info("Session idle, terminating", "job_id", s.job.ID[:8], "idle_timeout", timeout)
+				s.cancel(fmt.Errorf("%w of %v", errSessionIdle, timeout))
+				return
+			}
+			timer.Reset(timeout - idle)
+		}
+	}
+}
+
+// wait finishes the session job once the command has exited
+func (s *Session) wait(ctx context.Context, cmd *exec.Cmd, group *processGroup, output *jobOutput, reader *io.PipeWriter, copying *sync.WaitGroup) {
+	e := s.executor
+	job := s.job
+
+	err := cmd.Wait()
+	ctxErr := ctx.Err()
+
+	// like for regular jobs, background processes holding the terminal get the grace period to exit
+	gracePeriod := time.Duration(job.GracePeriod) * time.Second
+	if !waitGroupTimeout(copying, gracePeriod+time.Second) {
+		slog.Warn("Terminal held open by background processes, not reading further output",
+			"job_id", job.ID[:8])
+	}
+	s.master.Close()
+	copying.Wait()
+	reader.Close()
+	group.stop()
+	job.TerminationSignal = group.terminationSignal()
+	job.Resources = s.cgroup.usage()
+	recordRusage(job, cmd.ProcessState)
+	s.cgroup.remove()
+
+	job.Stdout = output.stdout.String()
+	job.StdoutBytes = output.stdout.Size()
+	job.OutputTruncated = output.stdout.Truncated()
+
+	exitCode := 0
+	job.Status = storage.StatusCompleted
+	if err != nil {
+		exitCode = -1
+		if exitError, ok := err.(*exec.ExitError); ok {
+			exitCode = exitError.ExitCode()
+		}
+		job.Status = storage.StatusFailed
+		job.Error = err.Error()
+	}
+	job.ExitCode = &exitCode
+	markOOMKilled(job)
+
+	switch cause := context.Cause(ctx); {
+	case ctxErr == nil:
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		job.Status = storage.StatusFailed
+		job.Error = "command timed out"
+		job.FailureReason = storage.FailureTimeout
+	case errors.Is(cause, errSessionIdle):
+		job.Status = storage.StatusFailed
+		job.Error = cause.Error()
+	case errors.Is(cause, errSessionDetached):
+		job.Status = storage.StatusCancelled
+		job.Error = cause.Error()
+	default:
+		job.Status = storage.StatusCancelled
+		job.Error = "command cancelled"
+	}
+	s.cancel(nil)
+
+	completedAt := time.Now()
+	job.CompletedAt = &completedAt
+	job.DurationMs = completedAt.Sub(*job.StartedAt).Milliseconds()
+
+	e.logJobCompletion(job)
+
+	if err := e.storage.Save(job); err != nil {
+		slog.Error("Failed to save session", "job_id", job.ID[:8], "error", err)
+	}
+
+	e.mutex.Lock()
+	delete(e.cancelFuncs, job.ID)
+	e.sessions--
+	e.releaseJobLocked(job.ID)
+	e.finishJobLocked(job)
+	e.mutex.Unlock()
+
+	close(s.done)
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func startTestSession(t *testing.T, e *Executor, opts SessionOptions, command string, args ...string) *Session {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("interactive sessions are only supported on Linux")
+	}
+
+	session, err := e.StartSession(&storage.ExecuteRequest{Command: command, Args: args, SubmittedBy: "tester"}, opts)
+	require.NoError(t, err)
+	return session
+}
+
+func TestSession(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	session := startTestSession(t, e, SessionOptions{Rows: 24, Cols: 80}, "sh")
+
+	running, err := session.Job()
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusRunning, running.Status)
+	assert.True(t, running.Interactive)
+
+	require.NoError(t, session.Resize(40, 100))
+	_, err = session.Write([]byte("stty size; echo term=$TERM; exit 3\n"))
+	require.NoError(t, err)
+
+	output, err := io.ReadAll(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "40 100")
+	assert.Contains(t, string(output), "term=xterm-256color")
+
+	<-session.Done()
+	job, err := session.Job()
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusFailed, job.Status)
+	require.NotNil(t, job.ExitCode)
+	assert.Equal(t, 3, *job.ExitCode)
+	assert.Equal(t, "tester", job.SubmittedBy)
+	assert.Contains(t, job.Stdout, "term=xterm-256color", "the terminal output is recorded")
+
+	jobs, _, err := e.ListJobs("", 0, 0, nil)
+	require.NoError(t, err)
+	assert.Len(t, jobs, 1, "sessions appear in the job list")
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	session := startTestSession(t, e, SessionOptions{IdleTimeout: 200 * time.Millisecond}, "sleep", "30")
+
+	go io.Copy(io.Discard, session)
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session was not terminated")
+	}
+
+	job, err := session.Job()
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusFailed, job.Status)
+	assert.Contains(t, job.Error, "idle")
+}
+
+func TestSessionClose(t *testing.T) {
+	e := newTestExecutor(t, 1)
+	session := startTestSession(t, e, SessionOptions{}, "sleep", "30")
+
+	session.Close()
+
+	job, err := session.Job()
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCancelled, job.Status)
+	assert.NotEmpty(t, job.TerminationSignal)
+}
+
+func TestSessionLimit(t *testing.T) {
+	e := NewExecutor(Config{MaxConcurrentJobs: 1, MaxSessions: 1, OutputDir: t.TempDir()}, storage.NewMemory())
+	session := startTestSession(t, e, SessionOptions{}, "sleep", "30")
+	defer session.Close()
+
+	_, err := e.StartSession(&storage.ExecuteRequest{Command: "sleep", Args: []string{"30"}}, SessionOptions{})
+	assert.ErrorIs(t, err, ErrSessionLimit)
+}
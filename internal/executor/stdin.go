@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	StdinEncodingText   = "text"
+	StdinEncodingBase64 = "base64"
+)
+
+// stdinSource is the input fed to a job, either held in memory or spooled to a file
+type stdinSource struct {
+	data []byte
+	path string
+}
+
+func newStdinSource(req *storage.ExecuteRequest) (*stdinSource, error) {
+	if req.StdinFile != "" {
+		return &stdinSource{path: req.StdinFile}, nil
+	}
+	if req.Stdin == "" {
+		return nil, nil
+	}
+
+	switch req.StdinEncoding {
+	case "", StdinEncodingText:
+		return &stdinSource{data: []byte(req.Stdin)}, nil
+	case StdinEncodingBase64:
+		data, err := base64.StdEncoding.DecodeString(req.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid base64 stdin: %v", ErrInvalidRequest, err)
+		}
+		return &stdinSource{data: data}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown stdin encoding: %s", ErrInvalidRequest, req.StdinEncoding)
+	}
+}
+
+// open returns a reader of the input, the caller is responsible for closing it
+func (s *stdinSource) open() (io.ReadCloser, error) {
+	if s.path != "" {
+		return os.Open(s.path)
+	}
+	return io.NopCloser(bytes.NewReader(s.data)), nil
+}
+
+// release removes the spooled input file, if any
+func (s *stdinSource) release() {
+	if s.path == "" {
+		return
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove stdin file", "path", s.path, "error", err)
+	}
+}
@@ -0,0 +1,245 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// ExecutorStats provides the executor load
+type ExecutorStats interface {
+	RunningJobs() int
+	QueueLength() int
+	MaxConcurrentJobs() int
+}
+
+type executorSaturation struct {
+	executor ExecutorStats
+
+	mutex     sync.Mutex
+	lastQueue int
+}
+
+// ExecutorSaturation reports the agent as degraded when all workers are busy and the queue grew since the
+// previous check, i.e. jobs are submitted faster than they are executed
+func ExecutorSaturation(executor ExecutorStats) Check {
+	return &executorSaturation{executor: executor}
+}
+
+func (c *executorSaturation) Name() string {
+	return "executor"
+}
+
+func (c *executorSaturation) Check(context.Context) storage.HealthCheck {
+	running := c.executor.RunningJobs()
+	queued := c.executor.QueueLength()
+	capacity := c.executor.MaxConcurrentJobs()
+
+	c.mutex.Lock()
+	growing := queued > c.lastQueue
+	c.lastQueue = queued
+	c.mutex.Unlock()
+
+	result := storage.HealthCheck{
+		Status: StatusHealthy,
+		Details: map[string]interface{}{
+			"running_jobs":        running,
+			"queued_jobs":         queued,
+			"max_concurrent_jobs": capacity,
+		},
+	}
+	if running >= capacity && growing {
+		result.Status = StatusDegraded
+		result.Message = "all workers are busy and the queue is growing"
+	}
+	return result
+}
+
+type memoryUsage struct {
+	degradedBytes  uint64
+	unhealthyBytes uint64
+}
+
+// MemoryUsage reports the agent as degraded or unhealthy when the memory allocated by the agent exceeds
+// the thresholds, 0 disables a threshold
+func MemoryUsage(degradedBytes, unhealthyBytes uint64) Check {
+	return &memoryUsage{degradedBytes: degradedBytes, unhealthyBytes: unhealthyBytes}
+}
+
+func (c *memoryUsage) Name() string {
+	return "memory"
+}
+
+func (c *memoryUsage) Check(context.Context) storage.HealthCheck {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	result := storage.HealthCheck{
+		Status: StatusHealthy,
+		Details: map[string]interface{}{
+			"alloc_mb": memStats.Alloc / (1024 * 1024),
+			"sys_mb":   memStats.Sys / (1024 * 1024),
+		},
+	}
+	switch {
+	case c.unhealthyBytes > 0 && memStats.Alloc > c.unhealthyBytes:
+		result.Status = StatusUnhealthy
+		result.Message = fmt.Sprintf("allocated memory exceeds %d MB", c.unhealthyBytes/(1024*1024))
+	case c.degradedBytes > 0 && memStats.Alloc > c.degradedBytes:
+		result.Status = StatusDegraded
+		result.Message = fmt.Sprintf("allocated memory exceeds %d MB", c.degradedBytes/(1024*1024))
+	}
+	return result
+}
+
+type diskFree struct {
+	paths          []string
+	degradedBytes  uint64
+	unhealthyBytes uint64
+}
+
+// DiskFree reports the agent as degraded or unhealthy when the space available on the file system of any
+// of the paths drops below the thresholds, 0 disables a threshold. Paths which do not exist yet are checked
+// on the file system of their nearest existing parent.
+func DiskFree(paths []string, degradedBytes, unhealthyBytes uint64) Check {
+	return &diskFree{paths: paths, degradedBytes: degradedBytes, unhealthyBytes: unhealthyBytes}
+}
+
+func (c *diskFree) Name() string {
+	return "disk"
+}
+
+func (c *diskFree) Check(context.Context) storage.HealthCheck {
+	result := storage.HealthCheck{
+		Status:  StatusHealthy,
+		Details: make(map[string]interface{}, len(c.paths)),
+	}
+
+	for _, path := range c.paths {
+		free, err := availableBytes(path)
+		if err != nil {
+			result.Status = StatusUnhealthy
+			result.Message = fmt.Sprintf("cannot check free space of %s: %v", path, err)
+			continue
+		}
+		result.Details[path+"_free_mb"] = free / (1024 * 1024)
+
+		switch {
+		case c.unhealthyBytes > 0 && free < c.unhealthyBytes:
+			result.Status = StatusUnhealthy
+			result.Message = fmt.Sprintf("less than %d MB free on %s", c.unhealthyBytes/(1024*1024), path)
+		case c.degradedBytes > 0 && free < c.degradedBytes && result.Status == StatusHealthy:
+			result.Status = StatusDegraded
+			result.Message = fmt.Sprintf("less than %d MB free on %s", c.degradedBytes/(1024*1024), path)
+		}
+	}
+	return result
+}
+
+func availableBytes(path string) (uint64, error) {
+	for {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(path, &stat)
+		if err == nil {
+			return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
+
+type storageResponsiveness struct {
+	store           storage.Storage
+	degradedLatency time.Duration
+}
+
+// StorageResponsiveness reports the agent as degraded when querying the job storage takes longer than
+// degradedLatency. A storage which does not respond at all makes the check time out, which is unhealthy.
+func StorageResponsiveness(store storage.Storage, degradedLatency time.Duration) Check {
+	return &storageResponsiveness{store: store, degradedLatency: degradedLatency}
+}
+
+func (c *storageResponsiveness) Name() string {
+	return "storage"
+}
+
+func (c *storageResponsiveness) Check(context.Context) storage.HealthCheck {
+	start := time.Now()
+	jobs := c.store.Count()
+	latency := time.Since(start)
+
+	result := storage.HealthCheck{
+		Status: StatusHealthy,
+		Details: map[string]interface{}{
+			"jobs":       jobs,
+			"latency_ms": latency.Milliseconds(),
+		},
+	}
+	if c.degradedLatency > 0 && latency > c.degradedLatency {
+		result.Status = StatusDegraded
+		result.Message = fmt.Sprintf("storage responded in %v", latency.Round(time.Millisecond))
+	}
+	return result
+}
+
+type stuckJobs struct {
+	store storage.Storage
+	slack time.Duration
+}
+
+// StuckJobs reports the agent as degraded when jobs are still running after their timeout and grace period
+// have elapsed, extended by slack, which means the agent failed to terminate them. The timeout of retried jobs
+// applies to their current attempt.
+func StuckJobs(store storage.Storage, slack time.Duration) Check {
+	return &stuckJobs{store: store, slack: slack}
+}
+
+func (c *stuckJobs) Name() string {
+	return "stuck_jobs"
+}
+
+func (c *stuckJobs) Check(context.Context) storage.HealthCheck {
+	running, _, err := c.store.List(storage.StatusRunning, 0, 0, nil)
+	if err != nil {
+		return storage.HealthCheck{
+			Status:  StatusUnhealthy,
+			Message: fmt.Sprintf("failed to list running jobs: %v", err),
+		}
+	}
+
+	now := time.Now()
+	var stuck []string
+	for _, job := range running {
+		// interactive sessions may run without a timeout
+		if job.StartedAt == nil || job.Timeout == 0 {
+			continue
+		}
+		started := *job.StartedAt
+		if job.AttemptStartedAt != nil {
+			started = *job.AttemptStartedAt
+		}
+		deadline := started.Add(time.Duration(job.Timeout+job.GracePeriod)*time.Second + c.slack)
+		if now.After(deadline) {
+			stuck = append(stuck, job.ID)
+		}
+	}
+
+	if len(stuck) == 0 {
+		return storage.HealthCheck{Status: StatusHealthy}
+	}
+	return storage.HealthCheck{
+		Status:  StatusDegraded,
+		Message: fmt.Sprintf("%d jobs are running past their timeout", len(stuck)),
+		Details: map[string]interface{}{"job_ids": stuck},
+	}
+}
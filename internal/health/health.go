@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	StatusHealthy   = "healthy"
+	StatusDegraded  = "degraded"
+	StatusUnhealthy = "unhealthy"
+)
+
+// severity orders the statuses, the overall status is the most severe status of all checks
+var severity = map[string]int{
+	StatusHealthy:   0,
+	StatusDegraded:  1,
+	StatusUnhealthy: 2,
+}
+
+// Check is a single aspect of the agent health
+type Check interface {
+	Name() string
+	Check(ctx context.Context) storage.HealthCheck
+}
+
+// Report is the outcome of running all health checks
+type Report struct {
+	Status    string
+	Checks    map[string]storage.HealthCheck
+	CheckedAt time.Time
+}
+
+// Checker runs the registered checks and rolls their results up into an overall status
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+
+	mutex sync.RWMutex
+	last  *Report
+}
+
+// NewChecker creates a checker running the checks concurrently, a check not finished within timeout
+// is reported as unhealthy
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Run runs all checks and stores the report, which is then returned by Last
+func (c *Checker) Run(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]storage.HealthCheck, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	report := &Report{
+		Status:    StatusHealthy,
+		Checks:    make(map[string]storage.HealthCheck, len(c.checks)),
+		CheckedAt: time.Now(),
+	}
+	for i, check := range c.checks {
+		report.Checks[check.Name()] = results[i]
+		if severity[results[i].Status] > severity[report.Status] {
+			report.Status = results[i].Status
+		}
+	}
+
+	c.mutex.Lock()
+	c.last = report
+	c.mutex.Unlock()
+	return report
+}
+
+// runCheck returns the result of the check or an unhealthy result if it does not finish in time
+func runCheck(ctx context.Context, check Check) storage.HealthCheck {
+	done := make(chan storage.HealthCheck, 1)
+	go func() {
+		done <- check.Check(ctx)
+	}()
+
+	select {
+	case result := <-done:
+		if _, known := severity[result.Status]; !known {
+			result.Status = StatusUnhealthy
+		}
+		return result
+	case <-ctx.Done():
+		return storage.HealthCheck{
+			Status:  StatusUnhealthy,
+			Message: fmt.Sprintf("check did not finish in time: %v", ctx.Err()),
+		}
+	}
+}
+
+// Watch runs the checks every interval until the context is done. Some checks, e.g. the executor
+// saturation, compare consecutive runs, so they are meant to be run periodically.
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Run(ctx)
+		}
+	}
+}
+
+// Last returns the most recent report, running the checks if they have not been run yet
+func (c *Checker) Last(ctx context.Context) *Report {
+	c.mutex.RLock()
+	last := c.last
+	c.mutex.RUnlock()
+
+	if last != nil {
+		return last
+	}
+	return c.Run(ctx)
+}
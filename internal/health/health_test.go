@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+type staticCheck struct {
+	name   string
+	status string
+	delay  time.Duration
+}
+
+func (c *staticCheck) Name() string {
+	return c.name
+}
+
+func (c *staticCheck) Check(ctx context.Context) storage.HealthCheck {
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+	}
+	return storage.HealthCheck{Status: c.status}
+}
+
+func TestCheckerRollUp(t *testing.T) {
+	checker := NewChecker(time.Second, &staticCheck{name: "a", status: StatusHealthy})
+	assert.Equal(t, StatusHealthy, checker.Run(context.Background()).Status)
+
+	checker = NewChecker(time.Second,
+		&staticCheck{name: "a", status: StatusHealthy},
+		&staticCheck{name: "b", status: StatusDegraded})
+	assert.Equal(t, StatusDegraded, checker.Run(context.Background()).Status)
+
+	checker = NewChecker(time.Second,
+		&staticCheck{name: "a", status: StatusUnhealthy},
+		&staticCheck{name: "b", status: StatusDegraded})
+	report := checker.Run(context.Background())
+	assert.Equal(t, StatusUnhealthy, report.Status)
+	assert.Len(t, report.Checks, 2)
+	assert.Same(t, report, checker.Last(context.Background()))
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	checker := NewChecker(50*time.Millisecond, &staticCheck{name: "slow", status: StatusHealthy, delay: time.Hour})
+	report := checker.Run(context.Background())
+	assert.Equal(t, StatusUnhealthy, report.Status)
+	assert.Contains(t, report.Checks["slow"].Message, "did not finish in time")
+}
+
+type fakeExecutor struct {
+	running, queued, capacity int
+}
+
+func (e *fakeExecutor) RunningJobs() int       { return e.running }
+func (e *fakeExecutor) QueueLength() int       { return e.queued }
+func (e *fakeExecutor) MaxConcurrentJobs() int { return e.capacity }
+
+func TestExecutorSaturation(t *testing.T) {
+	executor := &fakeExecutor{running: 2, capacity: 2}
+	check := ExecutorSaturation(executor)
+
+	assert.Equal(t, StatusHealthy, check.Check(context.Background()).Status)
+
+	executor.queued = 5
+	assert.Equal(t, StatusDegraded, check.Check(context.Background()).Status, "queue grew with all workers busy")
+
+	assert.Equal(t, StatusHealthy, check.Check(context.Background()).Status, "queue is not growing anymore")
+
+	executor.running = 1
+	executor.queued = 10
+	assert.Equal(t, StatusHealthy, check.Check(context.Background()).Status, "workers are available")
+}
+
+func TestDiskFree(t *testing.T) {
+	dir := t.TempDir()
+
+	result := DiskFree([]string{dir + "/not/created/yet"}, 0, 1).Check(context.Background())
+	assert.Equal(t, StatusHealthy, result.Status)
+
+	result = DiskFree([]string{dir}, 1<<62, 0).Check(context.Background())
+	assert.Equal(t, StatusDegraded, result.Status)
+
+	result = DiskFree([]string{dir}, 0, 1<<62).Check(context.Background())
+	assert.Equal(t, StatusUnhealthy, result.Status)
+}
+
+func TestStuckJobs(t *testing.T) {
+	store := storage.NewMemory()
+	check := StuckJobs(store, time.Minute)
+
+	longAgo := time.Now().Add(-time.Hour)
+	recently := time.Now().Add(-time.Minute)
+	require.NoError(t, store.Save(&storage.Job{ID: "stuck", Status: storage.StatusRunning, Timeout: 60, GracePeriod: 10, StartedAt: &longAgo}))
+	require.NoError(t, store.Save(&storage.Job{ID: "fine", Status: storage.StatusRunning, Timeout: 600, StartedAt: &recently}))
+	require.NoError(t, store.Save(&storage.Job{ID: "done", Status: storage.StatusCompleted, Timeout: 60, StartedAt: &longAgo}))
+	require.NoError(t, store.Save(&storage.Job{ID: "retried", Status: storage.StatusRunning, Timeout: 600, StartedAt: &longAgo,
+		Attempt: 3, AttemptStartedAt: &recently}))
+
+	result := check.Check(context.Background())
+	assert.Equal(t, StatusDegraded, result.Status)
+	assert.Equal(t, []string{"stuck"}, result.Details["job_ids"])
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const namespace = "sct_agent"
+
+// jobDurationBuckets span from sub-second commands to the multi-hour jobs of long SCT runs
+var jobDurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600, 7200, 14400}
+
+// Metrics holds the Prometheus collectors of the agent. All methods are no-ops on a nil *Metrics,
+// so components can be used without metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	jobsSubmitted *prometheus.CounterVec
+	jobsFinished  *prometheus.CounterVec
+	jobDuration   *prometheus.HistogramVec
+	queueWait     *prometheus.HistogramVec
+	httpDuration  *prometheus.HistogramVec
+	jobsPruned    prometheus.Counter
+}
+
+// New creates the collectors and registers them, together with the Go runtime and process collectors
+// (memory, CPU, file descriptors), in a dedicated registry
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		jobsSubmitted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_submitted_total",
+			Help:      "Number of jobs submitted, by priority.",
+		}, []string{"priority"}),
+		jobsFinished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_finished_total",
+			Help:      "Number of jobs finished, by final status.",
+		}, []string{"status"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Time jobs spent running, by final status.",
+			Buckets:   jobDurationBuckets,
+		}, []string{"status"}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_queue_wait_seconds",
+			Help:      "Time jobs spent queued before a worker started them, by priority.",
+			Buckets:   jobDurationBuckets,
+		}, []string{"priority"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests, by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "code"}),
+		jobsPruned: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_pruned_total",
+			Help:      "Number of finished jobs deleted by the retention policy.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.jobsSubmitted,
+		m.jobsFinished,
+		m.jobDuration,
+		m.queueWait,
+		m.httpDuration,
+		m.jobsPruned,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// RegisterExecutor exposes the current queue depth and number of running jobs, which are read
+// from the given functions on every scrape
+func (m *Metrics) RegisterExecutor(queueDepth, runningJobs func() int) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Number of jobs waiting for a worker.",
+		}, func() float64 { return float64(queueDepth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "running_jobs",
+			Help:      "Number of jobs currently running.",
+		}, func() float64 { return float64(runningJobs()) }),
+	)
+}
+
+func (m *Metrics) JobSubmitted(job *storage.Job) {
+	if m == nil {
+		return
+	}
+	m.jobsSubmitted.WithLabelValues(job.Priority).Inc()
+}
+
+// JobStarted records how long the job waited in the queue
+func (m *Metrics) JobStarted(job *storage.Job) {
+	if m == nil || job.StartedAt == nil {
+		return
+	}
+	m.queueWait.WithLabelValues(job.Priority).Observe(job.StartedAt.Sub(job.CreatedAt).Seconds())
+}
+
+// JobFinished records the final status of the job and, if it was started, its duration
+func (m *Metrics) JobFinished(job *storage.Job) {
+	if m == nil {
+		return
+	}
+	m.jobsFinished.WithLabelValues(string(job.Status)).Inc()
+	if job.StartedAt != nil && job.CompletedAt != nil {
+		m.jobDuration.WithLabelValues(string(job.Status)).Observe(job.CompletedAt.Sub(*job.StartedAt).Seconds())
+	}
+}
+
+// JobsPruned records jobs deleted by the retention policy
+func (m *Metrics) JobsPruned(count int) {
+	if m == nil {
+		return
+	}
+	m.jobsPruned.Add(float64(count))
+}
+
+// ObserveHTTPRequest records the latency of a request. route is the route pattern, e.g.
+// /api/v1/commands/:job_id, so that the number of series does not grow with job IDs.
+func (m *Metrics) ObserveHTTPRequest(method, route string, status int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(latency.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestJobMetrics(t *testing.T) {
+	m := New()
+	m.RegisterExecutor(func() int { return 3 }, func() int { return 2 })
+
+	created := time.Now().Add(-time.Minute)
+	started := created.Add(10 * time.Second)
+	completed := started.Add(20 * time.Second)
+	job := &storage.Job{Priority: "high", Status: storage.StatusCompleted, CreatedAt: created, StartedAt: &started, CompletedAt: &completed}
+
+	m.JobSubmitted(job)
+	m.JobStarted(job)
+	m.JobFinished(job)
+	m.JobFinished(&storage.Job{Priority: "low", Status: storage.StatusCancelled})
+	m.ObserveHTTPRequest("GET", "/api/v1/commands/:job_id", 200, 5*time.Millisecond)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.jobsSubmitted.WithLabelValues("high")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.jobsFinished.WithLabelValues("completed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.jobsFinished.WithLabelValues("cancelled")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.jobDuration), "jobs which never started have no duration")
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	assert.Contains(t, body, "sct_agent_queue_depth 3")
+	assert.Contains(t, body, "sct_agent_running_jobs 2")
+	assert.Contains(t, body, `sct_agent_job_queue_wait_seconds_sum{priority="high"} 10`)
+	assert.Contains(t, body, `sct_agent_http_request_duration_seconds_count{code="200",method="GET",route="/api/v1/commands/:job_id"} 1`)
+	assert.Contains(t, body, "process_resident_memory_bytes")
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.RegisterExecutor(func() int { return 0 }, func() int { return 0 })
+	m.JobSubmitted(&storage.Job{})
+	m.JobStarted(&storage.Job{})
+	m.JobFinished(&storage.Job{})
+	m.ObserveHTTPRequest("GET", "/health", 200, time.Millisecond)
+}
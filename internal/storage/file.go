@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const agentRestartedError = "agent restarted while the job was active"
+
+// File implements the Storage interface by keeping jobs in memory and persisting every job
+// as a JSON document in the data directory, so job records survive agent restarts
+type File struct {
+	*Memory
+	dir string
+}
+
+// NewFile opens the file storage in dataDir, loading all previously persisted jobs.
+// Jobs which were queued or running when the agent stopped are marked as failed.
+func NewFile(dataDir string) (*File, error) {
+	dir := filepath.Join(dataDir, "jobs")
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+
+	f := &File{
+		Memory: NewMemory(),
+		dir:    dir,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) load() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read jobs directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(f.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read job file %s: %w", path, err)
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			// a partially written file must not prevent the agent from starting
+			slog.Warn("Skipping corrupted job file", "path", path, "error", err)
+			continue
+		}
+
+		if job.Status == StatusQueued || job.Status == StatusRunning {
+			now := time.Now()
+			job.Status = StatusFailed
+			job.Error = agentRestartedError
+			job.CompletedAt = &now
+			if job.StartedAt != nil {
+				job.DurationMs = now.Sub(*job.StartedAt).Milliseconds()
+			}
+			if err := f.write(&job); err != nil {
+				return err
+			}
+		}
+
+		f.Memory.Save(&job)
+	}
+
+	return nil
+}
+
+func (f *File) Save(job *Job) error {
+	if err := f.write(job); err != nil {
+		return err
+	}
+	return f.Memory.Save(job)
+}
+
+func (f *File) Delete(id string) error {
+	if err := os.Remove(f.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove job file: %w", err)
+	}
+	return f.Memory.Delete(id)
+}
+
+// Cleanup deletes the finished jobs selected by the retention policy, including their files, and returns
+// their number
+func (f *File) Cleanup(policy RetentionPolicy) int {
+	count := 0
+	for _, id := range f.prunable(policy) {
+		if err := f.Delete(id); err != nil {
+			slog.Warn("Failed to delete pruned job", "job_id", id, "error", err)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// write persists the job atomically by writing a temporary file and renaming it
+func (f *File) write(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(f.dir, job.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create job file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync job file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close job file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path(job.ID)); err != nil {
+		return fmt.Errorf("failed to rename job file: %w", err)
+	}
+	return nil
+}
+
+func (f *File) path(id string) string {
+	return filepath.Join(f.dir, filepath.Base(id)+".json")
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoragePersistence(t *testing.T) {
+	dataDir := t.TempDir()
+
+	storage, err := NewFile(dataDir)
+	require.NoError(t, err)
+
+	exitCode := 0
+	startedAt := time.Now().Add(-time.Minute)
+	jobs := []*Job{
+		{ID: "completed-job", Command: "echo", Status: StatusCompleted, CreatedAt: time.Now(), ExitCode: &exitCode, Stdout: "hello\n"},
+		{ID: "running-job", Command: "sleep", Status: StatusRunning, CreatedAt: time.Now(), StartedAt: &startedAt},
+		{ID: "queued-job", Command: "ls", Status: StatusQueued, CreatedAt: time.Now()},
+		{ID: "deleted-job", Command: "ls", Status: StatusCompleted, CreatedAt: time.Now()},
+	}
+	for _, job := range jobs {
+		require.NoError(t, storage.Save(job))
+	}
+	require.NoError(t, storage.Delete("deleted-job"))
+
+	// simulate agent restart
+	reopened, err := NewFile(dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, reopened.Count())
+
+	completed, exists := reopened.Get("completed-job")
+	require.True(t, exists)
+	assert.Equal(t, StatusCompleted, completed.Status)
+	assert.Equal(t, "hello\n", completed.Stdout)
+	require.NotNil(t, completed.ExitCode)
+	assert.Equal(t, 0, *completed.ExitCode)
+
+	for _, id := range []string{"running-job", "queued-job"} {
+		job, exists := reopened.Get(id)
+		require.True(t, exists)
+		assert.Equal(t, StatusFailed, job.Status, "interrupted job %s should be marked as failed", id)
+		assert.Equal(t, agentRestartedError, job.Error)
+		assert.NotNil(t, job.CompletedAt)
+	}
+
+	_, exists = reopened.Get("deleted-job")
+	assert.False(t, exists)
+
+	// the failed state is persisted as well
+	again, err := NewFile(dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, again.CountByStatus(StatusFailed))
+}
+
+func TestFileStorageSkipsCorruptedFiles(t *testing.T) {
+	dataDir := t.TempDir()
+
+	storage, err := NewFile(dataDir)
+	require.NoError(t, err)
+	require.NoError(t, storage.Save(&Job{ID: "good-job", Command: "echo", Status: StatusCompleted, CreatedAt: time.Now()}))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "jobs", "bad-job.json"), []byte("{not json"), 0o640))
+
+	reopened, err := NewFile(dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reopened.Count())
+}
+
+func TestFileStorageCleanup(t *testing.T) {
+	dataDir := t.TempDir()
+
+	storage, err := NewFile(dataDir)
+	require.NoError(t, err)
+	require.NoError(t, storage.Save(&Job{ID: "old-job", Command: "echo", Status: StatusCompleted, CreatedAt: time.Now().Add(-25 * time.Hour)}))
+	require.NoError(t, storage.Save(&Job{ID: "recent-job", Command: "echo", Status: StatusCompleted, CreatedAt: time.Now()}))
+
+	assert.Equal(t, 1, storage.Cleanup(RetentionPolicy{MaxAge: 24 * time.Hour}))
+
+	_, err = os.Stat(filepath.Join(dataDir, "jobs", "old-job.json"))
+	assert.True(t, os.IsNotExist(err), "job file should be removed")
+
+	reopened, err := NewFile(dataDir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reopened.Count())
+}
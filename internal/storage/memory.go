@@ -5,7 +5,9 @@ import (
 	"time"
 )
 
-// Memory implements the Storage interface using in-memory storage
+// Memory implements the Storage interface using in-memory storage. It keeps its own copy of every saved job and
+// returns copies, so that jobs can be read while their owner keeps updating its instance. The slices and maps of
+// a saved job are shared with the copies and must not be modified in place.
 type Memory struct {
 	jobs  sync.Map
 	mutex sync.RWMutex
@@ -16,13 +18,15 @@ func NewMemory() *Memory {
 }
 
 func (m *Memory) Save(job *Job) error {
-	m.jobs.Store(job.ID, job)
+	stored := *job
+	m.jobs.Store(job.ID, &stored)
 	return nil
 }
 
 func (m *Memory) Get(id string) (*Job, bool) {
 	if job, exists := m.jobs.Load(id); exists {
-		return job.(*Job), true
+		found := *job.(*Job)
+		return &found, true
 	}
 	return nil, false
 }
@@ -41,7 +45,8 @@ func (m *Memory) List(status JobStatus, limit, offset int, since *time.Time) ([]
 			return true // continue iteration
 		}
 
-		allJobs = append(allJobs, job)
+		found := *job
+		allJobs = append(allJobs, &found)
 		return true
 	})
 
@@ -87,22 +92,23 @@ func (m *Memory) CountByStatus(status JobStatus) int {
 	return count
 }
 
-func (m *Memory) Cleanup(maxAge time.Duration) int {
-	cutoff := time.Now().Add(-maxAge)
-	var toDelete []string
-
-	m.jobs.Range(func(_, value interface{}) bool {
-		job := value.(*Job)
-		if job.CreatedAt.Before(cutoff) &&
-			(job.Status == StatusCompleted || job.Status == StatusFailed || job.Status == StatusCancelled) {
-			toDelete = append(toDelete, job.ID)
-		}
-		return true
-	})
-
+// Cleanup deletes the finished jobs selected by the retention policy and returns their number
+func (m *Memory) Cleanup(policy RetentionPolicy) int {
+	toDelete := m.prunable(policy)
 	for _, id := range toDelete {
 		m.jobs.Delete(id)
 	}
 
 	return len(toDelete)
 }
+
+// prunable returns IDs of finished jobs selected by the retention policy
+func (m *Memory) prunable(policy RetentionPolicy) []string {
+	var jobs []*Job
+	m.jobs.Range(func(_, value interface{}) bool {
+		jobs = append(jobs, value.(*Job))
+		return true
+	})
+
+	return policy.prunable(jobs, time.Now())
+}
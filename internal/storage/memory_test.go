@@ -108,7 +108,7 @@ func TestMemoryStorageCleanup(t *testing.T) {
 	assert.Equal(t, 3, storage.Count())
 
 	// cleanup jobs older than 24 hours
-	cleaned := storage.Cleanup(24 * time.Hour)
+	cleaned := storage.Cleanup(RetentionPolicy{MaxAge: 24 * time.Hour})
 	assert.Equal(t, 1, cleaned) // only old completed job should be cleaned
 	assert.Equal(t, 2, storage.Count())
 
@@ -122,3 +122,67 @@ func TestMemoryStorageCleanup(t *testing.T) {
 	_, exists = storage.Get("running-job")
 	assert.True(t, exists, "Running job should remain regardless of age")
 }
+
+func TestMemoryStorageRetention(t *testing.T) {
+	finished := func(id string, status JobStatus, age time.Duration, outputBytes int64) *Job {
+		completedAt := time.Now().Add(-age)
+		return &Job{ID: id, Status: status, CreatedAt: completedAt, CompletedAt: &completedAt, StdoutBytes: outputBytes}
+	}
+	remaining := func(storage *Memory) []string {
+		var ids []string
+		for _, id := range []string{"old-ok", "old-failed", "recent-ok", "recent-failed", "running"} {
+			if _, exists := storage.Get(id); exists {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	newStorage := func() *Memory {
+		storage := NewMemory()
+		for _, job := range []*Job{
+			finished("old-ok", StatusCompleted, 48*time.Hour, 100),
+			finished("old-failed", StatusFailed, 47*time.Hour, 100),
+			finished("recent-ok", StatusCompleted, 2*time.Hour, 100),
+			finished("recent-failed", StatusFailed, time.Hour, 100),
+			{ID: "running", Status: StatusRunning, CreatedAt: time.Now().Add(-72 * time.Hour)},
+		} {
+			require.NoError(t, storage.Save(job))
+		}
+		return storage
+	}
+
+	storage := newStorage()
+	assert.Equal(t, 0, storage.Cleanup(RetentionPolicy{}))
+
+	storage = newStorage()
+	assert.Equal(t, 1, storage.Cleanup(RetentionPolicy{MaxAge: 24 * time.Hour, FailedMaxAge: 72 * time.Hour}))
+	assert.Equal(t, []string{"old-failed", "recent-ok", "recent-failed", "running"}, remaining(storage))
+
+	storage = newStorage()
+	assert.Equal(t, 2, storage.Cleanup(RetentionPolicy{MaxJobs: 2}))
+	assert.Equal(t, []string{"recent-ok", "recent-failed", "running"}, remaining(storage), "the oldest jobs are pruned first")
+
+	storage = newStorage()
+	assert.Equal(t, 3, storage.Cleanup(RetentionPolicy{MaxOutputBytes: 150}))
+	assert.Equal(t, []string{"recent-failed", "running"}, remaining(storage))
+}
+
+func TestMemoryStorageCopies(t *testing.T) {
+	storage := NewMemory()
+
+	job := &Job{ID: "job", Status: StatusRunning, CreatedAt: time.Now()}
+	require.NoError(t, storage.Save(job))
+
+	// the owner keeps updating its instance until it saves it again
+	job.Status = StatusCompleted
+	retrieved, exists := storage.Get("job")
+	require.True(t, exists)
+	assert.Equal(t, StatusRunning, retrieved.Status)
+
+	retrieved.Status = StatusCancelled
+	jobs, _, err := storage.List("", 0, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, StatusRunning, jobs[0].Status)
+	assert.Equal(t, 1, storage.CountByStatus(StatusRunning))
+}
@@ -14,34 +14,235 @@ const (
 	StatusCancelled JobStatus = "cancelled"
 )
 
+// Statuses of pipeline steps which have no job, the other steps have the status of their job
+const (
+	// StepPending is the status of a step waiting for the steps it depends on
+	StepPending JobStatus = "pending"
+	// StepSkipped is the status of a step which did not run because a step it depends on did not complete
+	StepSkipped JobStatus = "skipped"
+)
+
+// IsTerminal tells whether the status is final, i.e. the job is not going to change anymore
+func (s JobStatus) IsTerminal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
 type Job struct {
-	ID          string            `json:"job_id"`
-	Command     string            `json:"command"`
-	Args        []string          `json:"args,omitempty"`
-	WorkingDir  string            `json:"working_dir,omitempty"`
-	Env         map[string]string `json:"env,omitempty"`
-	Timeout     int               `json:"timeout,omitempty"`
-	Priority    string            `json:"priority,omitempty"`
-	Tags        map[string]string `json:"tags,omitempty"`
-	Status      JobStatus         `json:"status"`
-	CreatedAt   time.Time         `json:"created_at"`
-	StartedAt   *time.Time        `json:"started_at,omitempty"`
-	CompletedAt *time.Time        `json:"completed_at,omitempty"`
-	ExitCode    *int              `json:"exit_code,omitempty"`
-	Stdout      string            `json:"stdout,omitempty"`
-	Stderr      string            `json:"stderr,omitempty"`
-	Error       string            `json:"error,omitempty"`
-	DurationMs  int64             `json:"duration_ms,omitempty"`
+	ID                string            `json:"job_id"`
+	Command           string            `json:"command"`
+	Args              []string          `json:"args,omitempty"`
+	WorkingDir        string            `json:"working_dir,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
+	EnvMode           string            `json:"env_mode,omitempty"`
+	Timeout           int               `json:"timeout,omitempty"`
+	Priority          string            `json:"priority,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	SubmittedBy       string            `json:"submitted_by,omitempty"`
+	RequestID         string            `json:"request_id,omitempty"`
+	PipelineID        string            `json:"pipeline_id,omitempty"`
+	Interactive       bool              `json:"interactive,omitempty"`
+	User              string            `json:"user,omitempty"`
+	Group             string            `json:"group,omitempty"`
+	Groups            []string          `json:"groups,omitempty"`
+	Limits            *ResourceLimits   `json:"limits,omitempty"`
+	OutputLimit       int64             `json:"output_limit,omitempty"`
+	KillSignal        string            `json:"kill_signal,omitempty"`
+	GracePeriod       int               `json:"grace_period,omitempty"`
+	Status            JobStatus         `json:"status"`
+	QueuePosition     int               `json:"queue_position,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
+	StartedAt         *time.Time        `json:"started_at,omitempty"`
+	CompletedAt       *time.Time        `json:"completed_at,omitempty"`
+	ExitCode          *int              `json:"exit_code,omitempty"`
+	TerminationSignal string            `json:"termination_signal,omitempty"`
+	Stdout            string            `json:"stdout,omitempty"`
+	Stderr            string            `json:"stderr,omitempty"`
+	StdoutBytes       int64             `json:"stdout_bytes"`
+	StderrBytes       int64             `json:"stderr_bytes"`
+	OutputTruncated   bool              `json:"output_truncated,omitempty"`
+	Error             string            `json:"error,omitempty"`
+	FailureReason     string            `json:"failure_reason,omitempty"`
+	DurationMs        int64             `json:"duration_ms,omitempty"`
+	Resources         *JobResources     `json:"resources,omitempty"`
+	Callback          *CallbackDelivery `json:"callback,omitempty"`
+	Retry             *RetryPolicy      `json:"retry,omitempty"`
+	Attempt           int               `json:"attempt,omitempty"`
+	AttemptStartedAt  *time.Time        `json:"attempt_started_at,omitempty"`
+	NextAttemptAt     *time.Time        `json:"next_attempt_at,omitempty"`
+	Attempts          []JobAttempt      `json:"attempts,omitempty"`
+}
+
+// Failure reasons of failed jobs which did not just exit with a non-zero code
+const (
+	FailureTimeout   = "timeout"
+	FailureOOMKilled = "oom_killed"
+)
+
+// ResourceLimits are the cgroup v2 limits of a job, unset fields do not limit
+type ResourceLimits struct {
+	// CPUQuota is the CPU time the job can use per wall clock time, in CPUs, e.g. 1.5
+	CPUQuota float64 `json:"cpu_quota,omitempty"`
+	// CPUWeight is the share of CPU time under contention, 1 to 10000 relative to the default of 100
+	CPUWeight int `json:"cpu_weight,omitempty"`
+	// MemoryMax is the memory limit in bytes, the job is killed by the OOM killer when exceeding it
+	MemoryMax int64 `json:"memory_max,omitempty"`
+	// PidsMax limits the number of processes and threads of the job
+	PidsMax int `json:"pids_max,omitempty"`
+	// IOWeight is the share of block IO under contention, 1 to 10000 relative to the default of 100
+	IOWeight int `json:"io_weight,omitempty"`
 }
 
+// JobResources are the resources used by a job, recorded when it ends. The rusage fields cover the command
+// and the descendants it waited for, the cgroup fields all processes of the job and are only set with cgroups.
+type JobResources struct {
+	UserCPUMs                  int64 `json:"user_cpu_ms"`
+	SystemCPUMs                int64 `json:"system_cpu_ms"`
+	MaxRSSBytes                int64 `json:"max_rss_bytes"`
+	VoluntaryContextSwitches   int64 `json:"voluntary_context_switches"`
+	InvoluntaryContextSwitches int64 `json:"involuntary_context_switches"`
+	// BlockInputOps and BlockOutputOps count the reads and writes which had to go to block devices
+	BlockInputOps  int64 `json:"block_input_ops"`
+	BlockOutputOps int64 `json:"block_output_ops"`
+
+	MemoryPeakBytes int64 `json:"memory_peak_bytes,omitempty"`
+	CPUTimeMs       int64 `json:"cpu_time_ms,omitempty"`
+	OOMKills        int   `json:"oom_kills,omitempty"`
+}
+
+const (
+	CallbackPending   = "pending"
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+)
+
+// Callback is a webhook the final job is POSTed to once it reaches a terminal state. When Secret is set,
+// the body is signed with HMAC-SHA256 in the X-SCT-Signature header.
+type Callback struct {
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	Secret  string            `json:"secret,omitempty" yaml:"secret"`
+}
+
+// RetryPolicy re-runs a failed job up to MaxAttempts times in total. A failed attempt is retried if its exit code is
+// one of OnExitCodes, its stderr matches the regular expression OnStderr or, with OnTimeout, it timed out. A policy
+// without any of these conditions retries every failure. Cancelled jobs are not retried.
+type RetryPolicy struct {
+	MaxAttempts int `json:"max_attempts"`
+	// BackoffSeconds is the delay before the first retry (1 if 0), multiplied by BackoffMultiplier (2 if 0) for
+	// every further retry up to MaxBackoffSeconds (24 hours if 0, which is also the upper bound)
+	BackoffSeconds    float64 `json:"backoff_seconds,omitempty"`
+	BackoffMultiplier float64 `json:"backoff_multiplier,omitempty"`
+	MaxBackoffSeconds float64 `json:"max_backoff_seconds,omitempty"`
+	OnExitCodes       []int   `json:"on_exit_codes,omitempty"`
+	OnStderr          string  `json:"on_stderr,omitempty"`
+	OnTimeout         bool    `json:"on_timeout,omitempty"`
+}
+
+// JobAttempt records a finished attempt of a job with a retry policy. Every attempt starts with empty output,
+// the output of the job is that of its last attempt. Stdout and Stderr hold the output of the attempt up to the
+// output limit of the job, StdoutBytes and StderrBytes its total size.
+type JobAttempt struct {
+	Attempt           int       `json:"attempt"`
+	Status            JobStatus `json:"status"`
+	StartedAt         time.Time `json:"started_at"`
+	CompletedAt       time.Time `json:"completed_at"`
+	DurationMs        int64     `json:"duration_ms"`
+	ExitCode          *int      `json:"exit_code,omitempty"`
+	TerminationSignal string    `json:"termination_signal,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	FailureReason     string    `json:"failure_reason,omitempty"`
+	Stdout            string    `json:"stdout,omitempty"`
+	Stderr            string    `json:"stderr,omitempty"`
+	StdoutBytes       int64     `json:"stdout_bytes"`
+	StderrBytes       int64     `json:"stderr_bytes"`
+	OutputTruncated   bool      `json:"output_truncated,omitempty"`
+}
+
+// CallbackDelivery records the delivery of a job to its callback, without the headers and secret
+type CallbackDelivery struct {
+	URL         string     `json:"url"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// ExecuteRequest describes a command to run. StdinFile and SubmittedBy are not part of the API, they are
+// set by the server: StdinFile for stdin uploaded as a multipart request part and spooled to disk,
+// SubmittedBy to the name of the API key which submitted the request.
 type ExecuteRequest struct {
-	Command    string            `json:"command" binding:"required"`
-	Args       []string          `json:"args"`
-	WorkingDir string            `json:"working_dir"`
-	Env        map[string]string `json:"env"`
-	Timeout    int               `json:"timeout"`
-	Priority   string            `json:"priority"`
-	Tags       map[string]string `json:"tags"`
+	Command       string            `json:"command" binding:"required"`
+	Args          []string          `json:"args"`
+	WorkingDir    string            `json:"working_dir"`
+	Env           map[string]string `json:"env"`
+	EnvMode       string            `json:"env_mode,omitempty"`
+	Timeout       int               `json:"timeout"`
+	Priority      string            `json:"priority"`
+	Tags          map[string]string `json:"tags"`
+	OutputLimit   int64             `json:"output_limit"`
+	Stdin         string            `json:"stdin,omitempty"`
+	StdinEncoding string            `json:"stdin_encoding,omitempty"`
+	KillSignal    string            `json:"kill_signal"`
+	GracePeriod   int               `json:"grace_period"`
+	User          string            `json:"user,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Groups        []string          `json:"groups,omitempty"`
+	Limits        *ResourceLimits   `json:"limits,omitempty"`
+	Callback      *Callback         `json:"callback,omitempty"`
+	Retry         *RetryPolicy      `json:"retry,omitempty"`
+	Wait          bool              `json:"wait,omitempty"`
+	MaxWait       int               `json:"max_wait,omitempty"`
+	RequestID     string            `json:"request_id,omitempty"`
+	StdinFile     string            `json:"-"`
+	SubmittedBy   string            `json:"-"`
+}
+
+// PipelineRequest describes a pipeline of commands run as a unit. SubmittedBy is set by the server like in
+// ExecuteRequest.
+type PipelineRequest struct {
+	Name        string            `json:"name,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Steps       []PipelineStep    `json:"steps"`
+	SubmittedBy string            `json:"-"`
+}
+
+// PipelineStep is a command of a pipeline, named step-<n> if Name is empty. A step runs once all steps named in
+// DependsOn completed, a step which failed or was cancelled skips the steps depending on it unless it has
+// ContinueOnFailure. If no step of a pipeline has DependsOn, every step depends on the previous one.
+type PipelineStep struct {
+	Name              string   `json:"name,omitempty"`
+	DependsOn         []string `json:"depends_on,omitempty"`
+	ContinueOnFailure bool     `json:"continue_on_failure,omitempty"`
+	ExecuteRequest
+}
+
+// Pipeline is the aggregated state of a pipeline. It is running until all of its steps finished or were skipped,
+// then it is cancelled if it was cancelled, failed if a step without ContinueOnFailure failed or was cancelled
+// and completed otherwise.
+type Pipeline struct {
+	ID          string               `json:"pipeline_id"`
+	Name        string               `json:"name,omitempty"`
+	Tags        map[string]string    `json:"tags,omitempty"`
+	SubmittedBy string               `json:"submitted_by,omitempty"`
+	Status      JobStatus            `json:"status"`
+	CreatedAt   time.Time            `json:"created_at"`
+	CompletedAt *time.Time           `json:"completed_at,omitempty"`
+	DurationMs  int64                `json:"duration_ms,omitempty"`
+	Steps       []PipelineStepResult `json:"steps"`
+}
+
+// PipelineStepResult is the state of a pipeline step, JobID is set once the step is submitted as a job
+type PipelineStepResult struct {
+	Name              string     `json:"name"`
+	DependsOn         []string   `json:"depends_on,omitempty"`
+	ContinueOnFailure bool       `json:"continue_on_failure,omitempty"`
+	Status            JobStatus  `json:"status"`
+	JobID             string     `json:"job_id,omitempty"`
+	ExitCode          *int       `json:"exit_code,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	StartedAt         *time.Time `json:"started_at,omitempty"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty"`
+	DurationMs        int64      `json:"duration_ms,omitempty"`
 }
 
 type ExecuteResponse struct {
@@ -59,6 +260,95 @@ type JobListResponse struct {
 	Offset   int   `json:"offset"`
 }
 
+// OutputEvent is sent by the stream endpoint for every chunk of job output
+type OutputEvent struct {
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	Data       string `json:"data"`
+}
+
+const (
+	SessionMessageStart   = "start"
+	SessionMessageStarted = "started"
+	SessionMessageResize  = "resize"
+	SessionMessageExit    = "exit"
+	SessionMessageError   = "error"
+)
+
+// SessionMessage is a control message of an interactive session, sent as a WebSocket text message. Terminal
+// input and output are sent as binary messages. The client sends "start" with the request first, the agent
+// answers with "started" once the command runs or with "error" and the status the request would have been
+// rejected with over HTTP, then closes the connection. The agent sends "exit" with the finished job before
+// closing the connection, the client sends "resize" when its terminal size changes.
+type SessionMessage struct {
+	Type    string          `json:"type"`
+	JobID   string          `json:"job_id,omitempty"`
+	Request *SessionRequest `json:"request,omitempty"`
+	Rows    uint16          `json:"rows,omitempty"`
+	Cols    uint16          `json:"cols,omitempty"`
+	Job     *Job            `json:"job,omitempty"`
+	Status  int             `json:"status,omitempty"`
+	Error   *ErrorResponse  `json:"error,omitempty"`
+}
+
+// SessionRequest describes the command of an interactive session like ExecuteRequest, Timeout and IdleTimeout
+// are in seconds. The terminal has Rows and Cols, if both are set, and is of type Term (xterm-256color if empty).
+// It is sent in the "start" message rather than in the URL, which proxies and access logs record.
+type SessionRequest struct {
+	Command     string            `json:"command"`
+	Args        []string          `json:"args,omitempty"`
+	WorkingDir  string            `json:"working_dir,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	EnvMode     string            `json:"env_mode,omitempty"`
+	User        string            `json:"user,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Groups      []string          `json:"groups,omitempty"`
+	Timeout     int               `json:"timeout,omitempty"`
+	Rows        uint16            `json:"rows,omitempty"`
+	Cols        uint16            `json:"cols,omitempty"`
+	Term        string            `json:"term,omitempty"`
+	IdleTimeout int               `json:"idle_timeout,omitempty"`
+}
+
+// CleanupResponse reports the jobs pruned by the retention policy
+type CleanupResponse struct {
+	Pruned    int `json:"pruned"`
+	Remaining int `json:"remaining"`
+}
+
+// AuditEvent records an API action. File is the path of uploaded and downloaded files, Request the execute
+// request and Pipeline the pipeline request with the values of sensitive variables masked and without stdin,
+// Error the message of failed actions.
+type AuditEvent struct {
+	Time       time.Time        `json:"time"`
+	Action     string           `json:"action"`
+	Identity   string           `json:"identity,omitempty"`
+	ClientIP   string           `json:"client_ip"`
+	Method     string           `json:"method"`
+	Path       string           `json:"path"`
+	Status     int              `json:"status"`
+	JobID      string           `json:"job_id,omitempty"`
+	PipelineID string           `json:"pipeline_id,omitempty"`
+	File       string           `json:"file,omitempty"`
+	Request    *ExecuteRequest  `json:"request,omitempty"`
+	Pipeline   *PipelineRequest `json:"pipeline,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// AuditResponse lists the audit events of a time range, oldest first. Truncated is set when more events
+// matched than the limit.
+type AuditResponse struct {
+	Events    []AuditEvent `json:"events"`
+	Truncated bool         `json:"truncated,omitempty"`
+}
+
+type FileInfo struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256"`
+}
+
 type HealthResponse struct {
 	Status        string                 `json:"status"`
 	Version       string                 `json:"version"`
@@ -66,6 +356,14 @@ type HealthResponse struct {
 	RunningJobs   int                    `json:"running_jobs"`
 	CompletedJobs int                    `json:"completed_jobs"`
 	System        map[string]interface{} `json:"system,omitempty"`
+	Checks        map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// HealthCheck is the result of a single health check, Status is one of healthy, degraded or unhealthy
+type HealthCheck struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -81,4 +379,6 @@ type Storage interface {
 	Delete(id string) error
 	Count() int
 	CountByStatus(status JobStatus) int
+	// Cleanup deletes the finished jobs selected by the retention policy and returns their number
+	Cleanup(policy RetentionPolicy) int
 }
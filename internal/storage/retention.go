@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// RetentionPolicy selects the finished jobs to be pruned, a zero value disables a limit. Queued and running
+// jobs are never pruned.
+type RetentionPolicy struct {
+	// MaxAge prunes jobs which finished longer ago
+	MaxAge time.Duration
+	// FailedMaxAge replaces MaxAge for failed jobs, so that they can be kept around longer for investigation
+	FailedMaxAge time.Duration
+	// MaxJobs prunes the oldest jobs beyond this number of finished jobs
+	MaxJobs int
+	// MaxOutputBytes prunes the oldest jobs until the total output of the finished jobs fits
+	MaxOutputBytes int64
+}
+
+// IsZero tells whether the policy prunes nothing
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// finishedAt returns when the job finished, jobs from older agents may only have the creation time
+func finishedAt(job *Job) time.Time {
+	if job.CompletedAt != nil {
+		return *job.CompletedAt
+	}
+	return job.CreatedAt
+}
+
+// prunable returns the IDs of the jobs to be pruned by the policy
+func (p RetentionPolicy) prunable(jobs []*Job, now time.Time) []string {
+	var finished []*Job
+	for _, job := range jobs {
+		if job.Status.IsTerminal() {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finishedAt(finished[i]).Before(finishedAt(finished[j]))
+	})
+
+	var totalOutput int64
+	for _, job := range finished {
+		totalOutput += job.StdoutBytes + job.StderrBytes
+	}
+
+	var ids []string
+	kept := len(finished)
+	for _, job := range finished {
+		maxAge := p.MaxAge
+		if job.Status == StatusFailed && p.FailedMaxAge > 0 {
+			maxAge = p.FailedMaxAge
+		}
+
+		expired := maxAge > 0 && now.Sub(finishedAt(job)) > maxAge
+		tooMany := p.MaxJobs > 0 && kept > p.MaxJobs
+		tooLarge := p.MaxOutputBytes > 0 && totalOutput > p.MaxOutputBytes
+		if !expired && !tooMany && !tooLarge {
+			continue
+		}
+
+		ids = append(ids, job.ID)
+		kept--
+		totalOutput -= job.StdoutBytes + job.StderrBytes
+	}
+	return ids
+}
@@ -1,14 +1,21 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/scylladb/sct-agent/internal/storage"
@@ -18,28 +25,99 @@ type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	apiKey     string
+	// retries is how often failed requests are retried, see WithRetries
+	retries int
+	// deduplicated is set once the agent confirmed that it deduplicates command submissions
+	deduplicated atomic.Bool
 }
 
-func NewClient(baseURL, apiKey string) *Client {
-	return &Client{
+const (
+	// longPollWait is how long a single request waits on the agent for a job to finish
+	longPollWait = 30 * time.Second
+	waitHeader   = "X-SCT-Wait"
+)
+
+// Option customizes the client created by NewClient
+type Option func(*Client)
+
+// NewClient creates a client of the agent at baseURL. apiKey may be empty when the client authenticates
+// with a TLS client certificate mapped to an identity by the agent.
+func NewClient(baseURL, apiKey string, options ...Option) *Client {
+	c := &Client{
 		baseURL: baseURL,
 		apiKey:  apiKey,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		retries: defaultRetries,
 	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
+// ExecuteCommand submits the command. The submission carries the request ID of req, or a generated one, so
+// retrying it after a network error or timeout does not execute the command twice.
 func (c *Client) ExecuteCommand(ctx context.Context, req *storage.ExecuteRequest) (*storage.ExecuteResponse, error) {
 	data, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := c.doRequest(ctx, http.MethodPost, "/api/v1/commands", bytes.NewReader(data))
+	httpReq, err := c.newRequest(ctx, http.MethodPost, "/api/v1/commands", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setRequestID(httpReq, req)
+
+	resp, err := c.send(c.httpClient, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var result storage.ExecuteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// ExecuteCommandWithStdin submits the command streaming stdin to the agent, which is meant for inputs
+// too large to be passed inline in ExecuteRequest.Stdin
+func (c *Client) ExecuteCommandWithStdin(ctx context.Context, req *storage.ExecuteRequest, stdin io.Reader) (*storage.ExecuteResponse, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeStdinForm(form, data, stdin))
+	}()
+
+	httpReq, err := c.newRequest(ctx, http.MethodPost, "/api/v1/commands", body)
 	if err != nil {
+		body.Close()
 		return nil, err
 	}
+	httpReq.Header.Set("Content-Type", form.FormDataContentType())
+	setRequestID(httpReq, req)
+
+	// uploads can take longer than the default client timeout
+	uploadClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.send(uploadClient, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
@@ -54,6 +132,26 @@ func (c *Client) ExecuteCommand(ctx context.Context, req *storage.ExecuteRequest
 	return &result, nil
 }
 
+func writeStdinForm(form *multipart.Writer, request []byte, stdin io.Reader) error {
+	part, err := form.CreateFormField("request")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(request); err != nil {
+		return err
+	}
+
+	part, err = form.CreateFormFile("stdin", "stdin")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, stdin); err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	return form.Close()
+}
+
 func (c *Client) GetJob(ctx context.Context, jobID string) (*storage.Job, error) {
 	resp, err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/commands/%s", jobID), nil)
 	if err != nil {
@@ -73,11 +171,61 @@ func (c *Client) GetJob(ctx context.Context, jobID string) (*storage.Job, error)
 	return &job, nil
 }
 
+// WaitForJob waits until the job reaches a terminal state. The agent is asked to hold each request until the job
+// finishes (long polling), older agents not supporting it are polled every pollInterval instead.
 func (c *Client) WaitForJob(ctx context.Context, jobID string, pollInterval time.Duration) (*storage.Job, error) {
 	if pollInterval == 0 {
 		pollInterval = time.Second
 	}
 
+	for {
+		job, supported, err := c.waitJob(ctx, jobID, longPollWait)
+		if err != nil {
+			return nil, err
+		}
+		done, err := jobDone(job)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return job, nil
+		}
+		if !supported {
+			return c.pollJob(ctx, jobID, pollInterval)
+		}
+	}
+}
+
+// waitJob gets the job, asking the agent to wait up to wait for it to finish. It also tells whether the agent
+// supports waiting.
+func (c *Client) waitJob(ctx context.Context, jobID string, wait time.Duration) (*storage.Job, bool, error) {
+	path := fmt.Sprintf("/api/v1/commands/%s?wait=%s", jobID, url.QueryEscape(wait.String()))
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the request takes longer than the default client timeout
+	waitClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.send(waitClient, req)
+	if err != nil {
+		return nil, false, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, c.handleErrorResponse(resp)
+	}
+
+	var job storage.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, false, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &job, resp.Header.Get(waitHeader) != "", nil
+}
+
+func (c *Client) pollJob(ctx context.Context, jobID string, pollInterval time.Duration) (*storage.Job, error) {
 	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
@@ -90,19 +238,29 @@ func (c *Client) WaitForJob(ctx context.Context, jobID string, pollInterval time
 			if err != nil {
 				return nil, err
 			}
-
-			switch job.Status {
-			case storage.StatusCompleted, storage.StatusFailed, storage.StatusCancelled:
+			done, err := jobDone(job)
+			if err != nil {
+				return nil, err
+			}
+			if done {
 				return job, nil
-			case storage.StatusQueued, storage.StatusRunning:
-				continue
-			default:
-				return nil, fmt.Errorf("unknown job status: %s", job.Status)
 			}
 		}
 	}
 }
 
+// jobDone tells whether the job reached a terminal state
+func jobDone(job *storage.Job) (bool, error) {
+	switch job.Status {
+	case storage.StatusCompleted, storage.StatusFailed, storage.StatusCancelled:
+		return true, nil
+	case storage.StatusQueued, storage.StatusRunning:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown job status: %s", job.Status)
+	}
+}
+
 func (c *Client) ExecuteAndWait(ctx context.Context, req *storage.ExecuteRequest, pollInterval time.Duration) (*storage.Job, error) {
 	jobResp, err := c.ExecuteCommand(ctx, req)
 	if err != nil {
@@ -112,6 +270,50 @@ func (c *Client) ExecuteAndWait(ctx context.Context, req *storage.ExecuteRequest
 	return c.WaitForJob(ctx, jobResp.JobID, pollInterval)
 }
 
+// Run executes the command and returns the finished job. The agent responds once the job finishes, which saves
+// the round trips of ExecuteAndWait for short commands. Jobs not finishing within maxWait (the agent default
+// if 0) are waited for with WaitForJob.
+func (c *Client) Run(ctx context.Context, req *storage.ExecuteRequest, maxWait time.Duration) (*storage.Job, error) {
+	syncReq := *req
+	syncReq.Wait = true
+	syncReq.MaxWait = int(maxWait.Seconds())
+
+	data, err := json.Marshal(&syncReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := c.newRequest(ctx, http.MethodPost, "/api/v1/commands", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setRequestID(httpReq, req)
+
+	// the request takes as long as the command, which may exceed the default client timeout
+	runClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.send(runClient, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	// a finished job is returned in full, otherwise only the fields of storage.ExecuteResponse are set
+	var job storage.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if job.Status.IsTerminal() {
+		return &job, nil
+	}
+
+	return c.WaitForJob(ctx, job.ID, 0)
+}
+
 func (c *Client) CancelJob(ctx context.Context, jobID string) error {
 	resp, err := c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/commands/%s", jobID), nil)
 	if err != nil {
@@ -126,11 +328,256 @@ func (c *Client) CancelJob(ctx context.Context, jobID string) error {
 	return nil
 }
 
+// ExecutePipeline submits the pipeline and returns its initial state. Unlike ExecuteCommand, the submission is
+// not retried after a network error since pipelines do not support request IDs.
+func (c *Client) ExecutePipeline(ctx context.Context, req *storage.PipelineRequest) (*storage.Pipeline, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := c.newRequest(ctx, http.MethodPost, "/api/v1/pipelines", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.send(c.httpClient, httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var pipeline storage.Pipeline
+	if err := json.NewDecoder(resp.Body).Decode(&pipeline); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &pipeline, nil
+}
+
+func (c *Client) GetPipeline(ctx context.Context, pipelineID string) (*storage.Pipeline, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/pipelines/%s", pipelineID), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var pipeline storage.Pipeline
+	if err := json.NewDecoder(resp.Body).Decode(&pipeline); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &pipeline, nil
+}
+
+// WaitForPipeline polls the pipeline every pollInterval until all of its steps finished
+func (c *Client) WaitForPipeline(ctx context.Context, pipelineID string, pollInterval time.Duration) (*storage.Pipeline, error) {
+	if pollInterval == 0 {
+		pollInterval = time.Second
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		pipeline, err := c.GetPipeline(ctx, pipelineID)
+		if err != nil {
+			return nil, err
+		}
+		if pipeline.Status.IsTerminal() {
+			return pipeline, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CancelPipeline cancels the running steps of the pipeline, the steps which did not start yet are never run
+func (c *Client) CancelPipeline(ctx context.Context, pipelineID string) error {
+	resp, err := c.doRequest(ctx, http.MethodDelete, fmt.Sprintf("/api/v1/pipelines/%s", pipelineID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+
+	return nil
+}
+
+type StreamOptions struct {
+	StdoutOffset int64
+	StderrOffset int64
+}
+
+// StreamEvent is a single event received from the job output stream. Type is "stdout" or "stderr" for
+// output chunks, "status" for the final job state and "error" when the stream broke.
+type StreamEvent struct {
+	Type   string
+	Output *storage.OutputEvent
+	Job    *storage.Job
+	Err    error
+}
+
+// StreamJob follows the output of a job as it is produced. The returned channel is closed after the
+// final "status" event, after an "error" event or when ctx is done.
+func (c *Client) StreamJob(ctx context.Context, jobID string, opts *StreamOptions) (<-chan StreamEvent, error) {
+	params := url.Values{}
+	if opts != nil {
+		if opts.StdoutOffset > 0 {
+			params.Set("stdout_offset", strconv.FormatInt(opts.StdoutOffset, 10))
+		}
+		if opts.StderrOffset > 0 {
+			params.Set("stderr_offset", strconv.FormatInt(opts.StderrOffset, 10))
+		}
+	}
+
+	path := fmt.Sprintf("/api/v1/commands/%s/stream", jobID)
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// the stream lasts as long as the job, so the default client timeout does not apply
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.send(streamClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	events := make(chan StreamEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		send := func(event StreamEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+		var eventType string
+		var data strings.Builder
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			case line == "" && eventType != "":
+				event, err := parseStreamEvent(eventType, data.String())
+				if err != nil {
+					send(StreamEvent{Type: "error", Err: err})
+					return
+				}
+				if !send(event) || event.Type == "status" {
+					return
+				}
+				eventType = ""
+				data.Reset()
+			}
+		}
+
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			send(StreamEvent{Type: "error", Err: fmt.Errorf("stream failed: %w", err)})
+		} else if ctx.Err() == nil {
+			send(StreamEvent{Type: "error", Err: fmt.Errorf("stream ended unexpectedly")})
+		}
+	}()
+
+	return events, nil
+}
+
+func parseStreamEvent(eventType, data string) (StreamEvent, error) {
+	event := StreamEvent{Type: eventType}
+	switch eventType {
+	case "status":
+		var job storage.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return event, fmt.Errorf("failed to decode status event: %w", err)
+		}
+		event.Job = &job
+	default:
+		var output storage.OutputEvent
+		if err := json.Unmarshal([]byte(data), &output); err != nil {
+			return event, fmt.Errorf("failed to decode %s event: %w", eventType, err)
+		}
+		event.Output = &output
+	}
+	return event, nil
+}
+
+// GetJobOutput returns up to length bytes of the job's stream ("stdout" or "stderr") starting at offset,
+// together with the total size of the stream. Zero length requests the server default.
+func (c *Client) GetJobOutput(ctx context.Context, jobID, stream string, offset, length int64) ([]byte, int64, error) {
+	params := url.Values{}
+	params.Set("stream", stream)
+	params.Set("offset", strconv.FormatInt(offset, 10))
+	if length > 0 {
+		params.Set("length", strconv.FormatInt(length, 10))
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, fmt.Sprintf("/api/v1/commands/%s/output?%s", jobID, params.Encode()), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, c.handleErrorResponse(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	total, err := strconv.ParseInt(resp.Header.Get("X-Output-Total-Bytes"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid X-Output-Total-Bytes header: %w", err)
+	}
+
+	return data, total, nil
+}
+
 type ListJobsOptions struct {
-	Status string
-	Limit  int
-	Offset int
-	Since  *time.Time
+	Status        string
+	Limit         int
+	Offset        int
+	Since         *time.Time
+	IncludeOutput bool
 }
 
 func (c *Client) ListJobs(ctx context.Context, opts *ListJobsOptions) (*storage.JobListResponse, error) {
@@ -149,6 +596,9 @@ func (c *Client) ListJobs(ctx context.Context, opts *ListJobsOptions) (*storage.
 		if opts.Since != nil {
 			params.Set("since", opts.Since.Format(time.RFC3339))
 		}
+		if opts.IncludeOutput {
+			params.Set("include_output", "true")
+		}
 	}
 
 	path := "/api/v1/commands"
@@ -174,6 +624,163 @@ func (c *Client) ListJobs(ctx context.Context, opts *ListJobsOptions) (*storage.
 	return &result, nil
 }
 
+type UploadOptions struct {
+	// Mode holds the permission bits of the file, 0644 if not set
+	Mode  os.FileMode
+	Owner string
+	Group string
+	// CreateDirs creates missing parent directories
+	CreateDirs bool
+	// SHA256 is the expected hex encoded checksum of the content, the upload fails on mismatch
+	SHA256 string
+}
+
+// UploadFile streams content into the file at remotePath on the agent host, replacing it atomically
+func (c *Client) UploadFile(ctx context.Context, remotePath string, content io.Reader, opts *UploadOptions) (*storage.FileInfo, error) {
+	params := url.Values{}
+	params.Set("path", remotePath)
+	if opts != nil {
+		if opts.Mode != 0 {
+			params.Set("mode", strconv.FormatUint(uint64(opts.Mode.Perm()), 8))
+		}
+		if opts.Owner != "" {
+			params.Set("owner", opts.Owner)
+		}
+		if opts.Group != "" {
+			params.Set("group", opts.Group)
+		}
+		if opts.CreateDirs {
+			params.Set("create_dirs", "true")
+		}
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, "/api/v1/files?"+params.Encode(), content)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if opts != nil && opts.SHA256 != "" {
+		req.Header.Set("X-Checksum-SHA256", opts.SHA256)
+	}
+
+	// transfers can take longer than the default client timeout
+	transferClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.send(transferClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var info storage.FileInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &info, nil
+}
+
+// DownloadFile writes the content of the file at remotePath on the agent host into w, verifying
+// its checksum, and returns the number of bytes written
+func (c *Client) DownloadFile(ctx context.Context, remotePath string, w io.Writer) (int64, error) {
+	params := url.Values{}
+	params.Set("path", remotePath)
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/files?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// transfers can take longer than the default client timeout
+	transferClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := c.send(transferClient, req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, c.handleErrorResponse(resp)
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(w, hash), resp.Body)
+	if err != nil {
+		return written, fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if expected := resp.Header.Get("X-Checksum-SHA256"); expected != "" {
+		if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(expected, actual) {
+			return written, fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+		}
+	}
+
+	return written, nil
+}
+
+// Cleanup makes the agent prune finished jobs according to its retention policy, which requires an
+// unrestricted API key
+func (c *Client) Cleanup(ctx context.Context) (*storage.CleanupResponse, error) {
+	resp, err := c.doRequest(ctx, http.MethodPost, "/api/v1/admin/cleanup", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var result storage.CleanupResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Audit returns the audit events of the agent between since and until, either may be zero for an open range,
+// oldest first and at most limit if greater than 0. It requires an unrestricted API key.
+func (c *Client) Audit(ctx context.Context, since, until time.Time, limit int) (*storage.AuditResponse, error) {
+	params := url.Values{}
+	if !since.IsZero() {
+		params.Set("since", since.Format(time.RFC3339))
+	}
+	if !until.IsZero() {
+		params.Set("until", until.Format(time.RFC3339))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := "/api/v1/audit"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	resp, err := c.doRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	var result storage.AuditResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Health returns the health of the agent. An unhealthy agent responds with 503 Service Unavailable,
+// which is not an error: the response tells which checks failed.
 func (c *Client) Health(ctx context.Context) (*storage.HealthResponse, error) {
 	resp, err := c.doRequest(ctx, http.MethodGet, "/health", nil)
 	if err != nil {
@@ -181,7 +788,7 @@ func (c *Client) Health(ctx context.Context) (*storage.HealthResponse, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
 		return nil, c.handleErrorResponse(resp)
 	}
 
@@ -193,21 +800,30 @@ func (c *Client) Health(ctx context.Context) (*storage.HealthResponse, error) {
 	return &health, nil
 }
 
-func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	if path != "/health" {
+	if path != "/health" && c.apiKey != "" {
 		req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	}
 
+	return req, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
 	if method == http.MethodPost && body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(c.httpClient, req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+func TestRunFallsBackToPolling(t *testing.T) {
+	// an older agent, which accepts the job without waiting for it and does not support long polling
+	var submitted *storage.ExecuteRequest
+	var polls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/commands", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&submitted))
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(storage.ExecuteResponse{JobID: "job-1", Status: storage.StatusQueued})
+	})
+	mux.HandleFunc("GET /api/v1/commands/job-1", func(w http.ResponseWriter, r *http.Request) {
+		job := storage.Job{ID: "job-1", Status: storage.StatusRunning}
+		if polls.Add(1) > 2 {
+			job.Status = storage.StatusCompleted
+			job.Stdout = "done\n"
+		}
+		json.NewEncoder(w).Encode(job)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job, err := NewClient(server.URL, "key").Run(ctx, &storage.ExecuteRequest{Command: "sleep", Args: []string{"2"}}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusCompleted, job.Status)
+	assert.Equal(t, "done\n", job.Stdout)
+	assert.Equal(t, int32(3), polls.Load(), "the job is polled until it finishes")
+
+	require.NotNil(t, submitted)
+	assert.True(t, submitted.Wait)
+	assert.Equal(t, 1, submitted.MaxWait)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+const (
+	// defaultRetries is how often a failed request is retried by default
+	defaultRetries = 5
+	// maxBackoff caps the delay between retries when the agent does not send Retry-After
+	maxBackoff = 30 * time.Second
+	// idempotencyKeyHeader carries the request ID of command submissions
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyWindowHeader is set on the responses to command submissions by agents deduplicating them
+	idempotencyWindowHeader = "Idempotency-Window"
+)
+
+// WithRetries sets how often requests are retried which the agent rejected with 429 Too Many Requests, because
+// of its rate limits or a full job queue, and command submissions which failed with a network error or timeout.
+// Submissions are retried after failures only once the agent confirmed that it deduplicates them, so that older
+// agents and agents with deduplication disabled never execute a command twice. 0 disables retrying.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// setRequestID sets the Idempotency-Key header to the request ID of req, generating one if it has none, so the
+// agent does not execute the command twice when the submission is retried
+func setRequestID(httpReq *http.Request, req *storage.ExecuteRequest) {
+	requestID := req.RequestID
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	httpReq.Header.Set(idempotencyKeyHeader, requestID)
+}
+
+// send sends the request with client, retrying while the agent responds with 429 Too Many Requests and, for
+// requests with an idempotency key sent to an agent which deduplicates them, while the request fails. Requests
+// with a body which cannot be replayed are not retried.
+func (c *Client) send(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		c.recordDeduplication(req, resp)
+		if attempt >= c.retries || !c.shouldRetry(req, resp, err) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		delay := backoff(attempt)
+		if resp != nil {
+			delay = retryDelay(resp, attempt)
+			resp.Body.Close()
+		}
+		// the jitter spreads the retries of clients which failed at the same time
+		delay += rand.N(delay/5 + 1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		retry := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retry.Body = body
+		}
+		req = retry
+	}
+}
+
+// shouldRetry reports whether a request is retried: after 429 Too Many Requests, or after failing if it has an
+// idempotency key and the agent confirmed that it deduplicates submissions, which prevents it from executing a
+// command twice
+func (c *Client) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Header.Get(idempotencyKeyHeader) != "" && c.deduplicated.Load() && req.Context().Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests
+}
+
+// recordDeduplication remembers whether the agent deduplicates submissions from the response to an accepted
+// submission, which reflects the current configuration of the agent
+func (c *Client) recordDeduplication(req *http.Request, resp *http.Response) {
+	if resp == nil || req.Header.Get(idempotencyKeyHeader) == "" ||
+		(resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted) {
+		return
+	}
+	c.deduplicated.Store(resp.Header.Get(idempotencyWindowHeader) != "")
+}
+
+// backoff returns the delay before a retry, doubling with every attempt
+func backoff(attempt int) time.Duration {
+	return min(time.Second<<attempt, maxBackoff)
+}
+
+// retryDelay returns the delay before retrying a request rejected with 429 Too Many Requests, as requested by
+// the Retry-After header or, without it, the backoff of the attempt
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	retryAfter := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(retryAfter); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return backoff(attempt)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// newFlakyAgent returns an agent accepting submissions, except for the one numbered failing whose connection is
+// closed without a response. Responses carry the Idempotency-Window header if deduplicates is set.
+func newFlakyAgent(t *testing.T, deduplicates bool, failing int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var submissions atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if submissions.Add(1) == failing {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+			return
+		}
+		if deduplicates {
+			w.Header().Set(idempotencyWindowHeader, "86400")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(storage.ExecuteResponse{JobID: r.Header.Get(idempotencyKeyHeader)})
+	}))
+	// net/http retries requests with an idempotency key itself when a reused connection fails
+	server.Config.SetKeepAlivesEnabled(false)
+	t.Cleanup(server.Close)
+	return server, &submissions
+}
+
+func TestSubmissionRetries(t *testing.T) {
+	t.Run("deduplicating agent", func(t *testing.T) {
+		server, submissions := newFlakyAgent(t, true, 2)
+		c := NewClient(server.URL, "key")
+
+		_, err := c.ExecuteCommand(context.Background(), &storage.ExecuteRequest{Command: "echo"})
+		require.NoError(t, err)
+		resp, err := c.ExecuteCommand(context.Background(), &storage.ExecuteRequest{Command: "echo", RequestID: "req-1"})
+		require.NoError(t, err)
+		assert.Equal(t, "req-1", resp.JobID, "the retry carries the same request ID")
+		assert.Equal(t, int32(3), submissions.Load())
+	})
+
+	t.Run("agent without deduplication", func(t *testing.T) {
+		server, submissions := newFlakyAgent(t, false, 2)
+		c := NewClient(server.URL, "key")
+
+		_, err := c.ExecuteCommand(context.Background(), &storage.ExecuteRequest{Command: "echo"})
+		require.NoError(t, err)
+		_, err = c.ExecuteCommand(context.Background(), &storage.ExecuteRequest{Command: "echo"})
+		require.Error(t, err)
+		assert.Equal(t, int32(2), submissions.Load(), "the failed submission is not retried")
+	})
+
+	t.Run("first submission", func(t *testing.T) {
+		server, submissions := newFlakyAgent(t, true, 1)
+		c := NewClient(server.URL, "key")
+
+		_, err := c.ExecuteCommand(context.Background(), &storage.ExecuteRequest{Command: "echo"})
+		require.Error(t, err, "nothing is known about the agent yet")
+		assert.Equal(t, int32(1), submissions.Load())
+	})
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+
+	"github.com/scylladb/sct-agent/internal/storage"
+)
+
+// SessionRequest describes an interactive session, a command running under a pseudo-terminal on the agent
+type SessionRequest struct {
+	Command    string
+	Args       []string
+	WorkingDir string
+	Env        map[string]string
+	// EnvMode selects how Env is combined with the agent environment, see storage.ExecuteRequest
+	EnvMode string
+	// User, Group and Groups run the command as another user, see storage.ExecuteRequest
+	User   string
+	Group  string
+	Groups []string
+	// Timeout terminates the session after this many seconds, no timeout if 0 unless the key policy sets one
+	Timeout int
+	// Rows and Cols are the initial terminal size, AttachTerminal uses the local terminal size if not set
+	Rows uint16
+	Cols uint16
+	// Term is the TERM of the command, AttachTerminal uses the local TERM if not set
+	Term string
+	// IdleTimeout terminates the session without input or output for this long, the agent default if 0
+	IdleTimeout time.Duration
+}
+
+// Session is an open interactive session. Reads return the terminal output and must keep up with it,
+// writes are sent to the terminal input.
+type Session struct {
+	conn   *websocket.Conn
+	jobID  string
+	output *io.PipeReader
+
+	writeMutex sync.Mutex
+	done       chan struct{}
+	job        *storage.Job
+	err        error
+}
+
+// OpenSession starts an interactive session on the agent
+func (c *Client) OpenSession(ctx context.Context, req *SessionRequest) (*Session, error) {
+	// http:// becomes ws:// and https:// becomes wss://, the request is sent over the connection rather than in
+	// the URL, which proxies and access logs record
+	endpoint := "ws" + strings.TrimPrefix(c.baseURL, "http") + "/api/v1/sessions"
+
+	header := http.Header{}
+	if c.apiKey != "" {
+		header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+	}
+	if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, endpoint, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			defer resp.Body.Close()
+			return nil, c.handleErrorResponse(resp)
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	start := storage.SessionMessage{Type: storage.SessionMessageStart, Request: &storage.SessionRequest{
+		Command:    req.Command,
+		Args:       req.Args,
+		WorkingDir: req.WorkingDir,
+		Env:        req.Env,
+		EnvMode:    req.EnvMode,
+		User:       req.User,
+		Group:      req.Group,
+		Groups:     req.Groups,
+		Timeout:    req.Timeout,
+		Term:       req.Term,
+		// the agent counts whole seconds
+		IdleTimeout: int((req.IdleTimeout + time.Second - 1) / time.Second),
+	}}
+	if req.Rows > 0 && req.Cols > 0 {
+		start.Request.Rows, start.Request.Cols = req.Rows, req.Cols
+	}
+	if err := conn.WriteJSON(start); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	var started storage.SessionMessage
+	if err := conn.ReadJSON(&started); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("session did not start: %w", err)
+	}
+	if started.Type == storage.SessionMessageError && started.Error != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP %d: %s - %s", started.Status, started.Error.Error, started.Error.Message)
+	}
+	if started.Type != storage.SessionMessageStarted {
+		conn.Close()
+		return nil, fmt.Errorf("session did not start: unexpected %q message", started.Type)
+	}
+
+	reader, writer := io.Pipe()
+	s := &Session{
+		conn:   conn,
+		jobID:  started.JobID,
+		output: reader,
+		done:   make(chan struct{}),
+	}
+	go s.receive(writer)
+
+	return s, nil
+}
+
+// JobID returns the ID of the job recording the session
+func (s *Session) JobID() string {
+	return s.jobID
+}
+
+// Read reads the terminal output, it returns io.EOF once the command has exited
+func (s *Session) Read(p []byte) (int, error) {
+	return s.output.Read(p)
+}
+
+// Write sends input to the terminal
+func (s *Session) Write(p []byte) (int, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize changes the size of the terminal
+func (s *Session) Resize(rows, cols uint16) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	return s.conn.WriteJSON(storage.SessionMessage{Type: storage.SessionMessageResize, Rows: rows, Cols: cols})
+}
+
+// Wait waits for the command to exit and returns its job, without the terminal output
+func (s *Session) Wait() (*storage.Job, error) {
+	<-s.done
+	return s.job, s.err
+}
+
+// Close closes the connection, which terminates the command if it is still running
+func (s *Session) Close() error {
+	s.writeMutex.Lock()
+	_ = s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	s.writeMutex.Unlock()
+	// unblocks the delivery of output nobody reads anymore
+	s.output.Close()
+	return s.conn.Close()
+}
+
+func (s *Session) receive(output *io.PipeWriter) {
+	defer close(s.done)
+
+	for {
+		messageType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if s.job == nil {
+				s.err = fmt.Errorf("session ended without exit status: %w", err)
+			}
+			output.CloseWithError(s.err)
+			return
+		}
+
+		switch messageType {
+		case websocket.BinaryMessage:
+			// output nobody reads anymore is dropped
+			_, _ = output.Write(data)
+		case websocket.TextMessage:
+			var message storage.SessionMessage
+			if err := json.Unmarshal(data, &message); err == nil && message.Type == storage.SessionMessageExit {
+				s.job = message.Job
+			}
+		}
+	}
+}
+
+// AttachTerminal runs the session attached to the local terminal until the command exits and returns its job.
+// Stdin is switched to raw mode if it is a terminal, so that keys like Ctrl-C are sent to the remote command,
+// and size changes of the local terminal are passed to the session.
+func (c *Client) AttachTerminal(ctx context.Context, req *SessionRequest) (*storage.Job, error) {
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+
+	attached := *req
+	if attached.Rows == 0 || attached.Cols == 0 {
+		if cols, rows, err := term.GetSize(stdoutFd); err == nil {
+			attached.Rows, attached.Cols = uint16(rows), uint16(cols)
+		}
+	}
+	if attached.Term == "" {
+		attached.Term = os.Getenv("TERM")
+	}
+
+	session, err := c.OpenSession(ctx, &attached)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	if term.IsTerminal(stdinFd) {
+		state, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to switch terminal to raw mode: %w", err)
+		}
+		defer term.Restore(stdinFd, state)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		session.Close()
+	}()
+	go watchTerminalSize(ctx, stdoutFd, func(rows, cols uint16) {
+		session.Resize(rows, cols)
+	})
+
+	// stdin cannot be interrupted, the copy ends with the next keystroke after the session is done
+	go io.Copy(session, os.Stdin)
+	io.Copy(os.Stdout, session)
+
+	job, err := session.Wait()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return job, err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package client
+
+import "context"
+
+// watchTerminalSize does nothing on platforms without SIGWINCH, the session keeps its initial size
+func watchTerminalSize(ctx context.Context, fd int, resize func(rows, cols uint16)) {}
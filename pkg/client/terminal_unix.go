@@ -0,0 +1,30 @@
+//go:build unix
+
+package client
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// watchTerminalSize calls resize with the new size of the terminal whenever it changes, until ctx is done
+func watchTerminalSize(ctx context.Context, fd int, resize func(rows, cols uint16)) {
+	changes := make(chan os.Signal, 1)
+	signal.Notify(changes, syscall.SIGWINCH)
+	defer signal.Stop(changes)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			if cols, rows, err := term.GetSize(fd); err == nil {
+				resize(uint16(rows), uint16(cols))
+			}
+		}
+	}
+}